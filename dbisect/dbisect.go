@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug    bool
+	copyFlag bool
+	nProcs   int
+	fromStr  string
+	untilStr string
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	c := flag.Bool("c", false, "copy each version to a temporary directory to test it")
+	p := flag.Int("p", runtime.NumCPU(), "number of dumps to look up in parallel")
+	s := flag.String("s", "", "earliest date or dump path, a good one")
+	e := flag.String("e", "", "latest date or dump path, a bad one")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	copyFlag = *c
+	nProcs = *p
+	fromStr = *s
+	untilStr = *e
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dbisect: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dbisect [-Dc] [-p=nprocs] [-s=earliest] [-e=latest] path cmd [arg...]")
+}
+
+//exit status of the commands for the versions which cannot be tested, as in git bisect run
+const skipStatus = 125
+
+//candidates returns the snapshots between -s and -e in which the path exists. For a file,
+//only the first snapshot of each version is a candidate, the others would test the same.
+func candidates(roots dnav.Roots, rel string) (cands []dnav.Snapshot, err error) {
+	var (
+		from  dnav.Snapshot
+		until dnav.DumpDate
+	)
+	if fromStr != "" {
+		d, err := dnav.ParseDateEnd(fromStr, roots)
+		if err != nil {
+			return nil, err
+		}
+		if from, err = dnav.FindSnapshot(d, roots); err != nil {
+			return nil, err
+		}
+	}
+	if untilStr != "" {
+		if until, err = dnav.ParseDateEnd(untilStr, roots); err != nil {
+			return nil, err
+		}
+	}
+	snaps, err := dnav.ListSnapshots(roots, dnav.DumpDate{}, nProcs)
+	if err != nil {
+		return nil, err
+	}
+	snaps = dnav.Until(snaps, until)
+	versions, err := dnav.History(snaps, roots, rel, nProcs)
+	if err != nil {
+		return nil, err
+	}
+	var last *dnav.Version
+	j := 0
+	for i := range snaps {
+		for j < len(versions) && versions[j].Snapshot.Date == snaps[i].Date {
+			last = &versions[j]
+			j++
+		}
+		if last == nil || last.Info == nil {
+			continue //absent
+		}
+		if last.Info.IsDir() || last.Snapshot.Date == snaps[i].Date {
+			cands = append(cands, snaps[i])
+		}
+	}
+	if fromStr != "" {
+		//the snapshot at from has the version of the last candidate not after it
+		k := -1
+		for i := range cands {
+			if !cands[i].Date.IsAfter(from.Date) {
+				k = i
+			}
+		}
+		if k < 0 {
+			return nil, errors.New("the path is not in the earliest dump")
+		}
+		cands = cands[k:]
+		cands[0] = from
+	}
+	return cands, nil
+}
+
+//copyTree copies a file or directory to dst, to test it without touching the dump
+func copyTree(src string, dst string) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		d := dst + strings.TrimPrefix(p, src)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(d, fi.Mode().Perm()|0700)
+		case fi.Mode()&os.ModeSymlink != 0:
+			l, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(l, d)
+		case !fi.Mode().IsRegular():
+			return nil
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(d, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm()|0200)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+//cmdArgs are the arguments of the command for the path of a version: it
+//replaces the arguments {}, or else it is the last one
+func cmdArgs(args []string, path string) []string {
+	var cargs []string
+	found := false
+	for _, a := range args {
+		if a == "{}" {
+			a, found = path, true
+		}
+		cargs = append(cargs, a)
+	}
+	if !found {
+		cargs = append(cargs, path)
+	}
+	return cargs
+}
+
+//test runs the command for the path of the version in the snapshot
+func test(s *dnav.Snapshot, roots dnav.Roots, rel string, args []string) (r int, err error) {
+	path := s.PathOf(rel, roots)
+	if copyFlag {
+		tmp, err := ioutil.TempDir("", "dbisect")
+		if err != nil {
+			return dnav.Skip, err
+		}
+		defer os.RemoveAll(tmp)
+		dst := filepath.Join(tmp, filepath.Base(path))
+		if err := copyTree(path, dst); err != nil {
+			return dnav.Skip, err
+		}
+		path = dst
+	}
+	cargs := cmdArgs(args, path)
+	Dprintf("running %q\n", cargs)
+	cmd := exec.Command(cargs[0], cargs[1:]...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	var ee *exec.ExitError
+	switch {
+	case err == nil:
+		r = dnav.Good
+	case errors.As(err, &ee) && ee.ExitCode() == skipStatus:
+		r = dnav.Skip
+	case errors.As(err, &ee) && ee.ExitCode() > 0 && ee.ExitCode() < 128:
+		r = dnav.Bad
+	default:
+		return dnav.Skip, fmt.Errorf("%s: %s", cargs[0], err)
+	}
+	fmt.Printf("%s\t%s\n", []string{"good", "bad", "skip"}[r], s.Date.Name())
+	return r, nil
+}
+
+func main() {
+	var roots dnav.Roots
+
+	rdFlags()
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	rel, err := dnav.RelPath(path, roots)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cands, err := candidates(roots, rel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Dprintf("%d candidates\n", len(cands))
+	if len(cands) < 2 {
+		log.Fatal("not enough versions of the path to bisect")
+	}
+	good, bad, err := dnav.Bisect(len(cands), func(i int) (int, error) {
+		return test(&cands[i], roots, rel, args[1:])
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if bad-good > 1 {
+		fmt.Printf("the first bad is one of\n")
+		for i := good + 1; i <= bad; i++ {
+			fmt.Printf("\t%s\n", cands[i].PathOf(rel, roots))
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("first bad\t%s\n", cands[bad].PathOf(rel, roots))
+	fmt.Printf("last good\t%s\n", cands[good].PathOf(rel, roots))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug    bool
+	hashFlag bool
+	quiet    bool
+	prunes   []string
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	h := flag.Bool("H", false, "compare the contents of the files with the same size")
+	q := flag.Bool("q", false, "quiet, only the exit status")
+	x := flag.String("x", "", "comma separated names of directories not to descend into")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	hashFlag = *h
+	quiet = *q
+	if *x != "" {
+		prunes = strings.Split(*x, ",")
+	}
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dcheck: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dcheck [-DHq] [-x=dir,dir...] path")
+}
+
+//isSafe finds if the live file is the same in the dump
+func isSafe(p string, fi os.FileInfo, dp string, dfi os.FileInfo) (bool, error) {
+	if fi.Mode().Type() != dfi.Mode().Type() {
+		return false, nil
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		l, err := os.Readlink(p)
+		if err != nil {
+			return false, err
+		}
+		dl, err := os.Readlink(dp)
+		if err != nil {
+			return false, err
+		}
+		return l == dl, nil
+	}
+	if fi.Size() != dfi.Size() {
+		return false, nil
+	}
+	if !hashFlag {
+		return fi.ModTime().Equal(dfi.ModTime()), nil
+	}
+	sum, err := dnav.HashFile(p)
+	if err != nil {
+		return false, err
+	}
+	dsum, err := dnav.HashFile(dp)
+	if err != nil {
+		return false, err
+	}
+	return sum == dsum, nil
+}
+
+//check walks the live tree under rel and prints the files which are not in the snapshot,
+//or are different there. It returns the number of them, counting the ones which cannot be
+//read as not safe. The error is for the root of the tree, if it cannot be checked at all.
+func check(s *dnav.Snapshot, roots dnav.Roots, rel string) (nUnsafe int, err error) {
+	prune := dnav.Prune(prunes)
+	root := dnav.LivePath(rel, roots)
+	err = filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil && p == root {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dcheck: %s\n", err)
+			nUnsafe++
+			return nil
+		}
+		r := strings.TrimPrefix(p, roots.MainRoot)
+		if fi.IsDir() {
+			if p != root && prune(r, fi) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() && fi.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		dp := s.PathOf(r, roots)
+		kind := "#new"
+		dfi, err := os.Lstat(dp)
+		if err == nil {
+			safe, err := isSafe(p, fi, dp, dfi)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "dcheck: %s\n", err)
+			}
+			if safe {
+				return nil
+			}
+			kind = "#modified"
+		}
+		nUnsafe++
+		if !quiet {
+			fmt.Printf("%s\t%s\n", kind, p)
+		}
+		return nil
+	})
+	return nUnsafe, err
+}
+
+func main() {
+	var roots dnav.Roots
+
+	rdFlags()
+	args := flag.Args()
+	if len(args) != 1 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dnav.IsDump(path, roots) {
+		log.Fatal("not a path in the main root: " + path)
+	}
+	rel, err := dnav.RelPath(path, roots)
+	if err != nil {
+		log.Fatal(err)
+	}
+	now := time.Now()
+	s, err := dnav.FindSnapshot(dnav.TInDumpDate(now), roots)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Dprintf("newest dump %s\n", s.Path)
+
+	nUnsafe, err := check(&s, roots, rel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !quiet {
+		age := dnav.FmtAge(now.Sub(s.Date.Time()))
+		fmt.Printf("#dump\t%s\t%s old\t%d files not in it\n", s.Path, age, nUnsafe)
+	}
+	if nUnsafe > 0 {
+		os.Exit(1)
+	}
+}
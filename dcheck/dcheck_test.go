@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestCheckCorrupted(t *testing.T) {
+	var roots dnav.Roots
+	tmp := t.TempDir()
+	live := tmp + "/ROOT"
+	os.MkdirAll(live+"/src", 0755)
+	os.MkdirAll(tmp+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmp+"/dump")
+	dnav.RdRoots(&roots)
+	quiet = true
+
+	os.WriteFile(live+"/a.txt", []byte("one\n"), 0644)
+	os.WriteFile(live+"/src/b.txt", []byte("two\n"), 0644)
+	os.WriteFile(live+"/src/c.txt", []byte("three\n"), 0644)
+	s, _, err := dnav.TakeSnapshot(roots, time.Date(2017, 5, 10, 16, 5, 0, 0, time.Local), nil)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if n, err := check(&s, roots, ""); err != nil || n != 0 {
+		t.Fatalf("should find every file in the dump: %d %v", n, err)
+	}
+
+	//one file lost and another one damaged in the dump, keeping its size and times
+	os.Remove(s.PathOf("/src/b.txt", roots))
+	dp := s.PathOf("/src/c.txt", roots)
+	fi, err := os.Stat(dp)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	os.Chmod(dp, 0644)
+	os.WriteFile(dp, []byte("thr33\n"), 0644)
+	os.Chtimes(dp, fi.ModTime(), fi.ModTime())
+
+	hashFlag = false
+	if n, err := check(&s, roots, "/src"); err != nil || n != 1 {
+		t.Errorf("should find the lost file: %d %v", n, err)
+	}
+	hashFlag = true
+	if n, err := check(&s, roots, "/src"); err != nil || n != 2 {
+		t.Errorf("should find the damaged file comparing the contents: %d %v", n, err)
+	}
+	if n, err := check(&s, roots, ""); err != nil || n != 2 {
+		t.Errorf("should only count the files under rel: %d %v", n, err)
+	}
+	prunes = []string{"src"}
+	if n, err := check(&s, roots, ""); err != nil || n != 0 {
+		t.Errorf("should not descend into the pruned directories: %d %v", n, err)
+	}
+	prunes = nil
+	if _, err := check(&s, roots, "/doesnotexist"); err == nil {
+		t.Errorf("should error when the path cannot be checked")
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug  bool
+	addr   string
+	nProcs int
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	a := flag.String("a", "localhost:8090", "address to listen to")
+	p := flag.Int("p", runtime.NumCPU(), "number of directories of the dump to read in parallel")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	addr = *a
+	nProcs = *p
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "ddav: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("ddav [-D] [-a=addr] [-p=nprocs]")
+}
+
+const (
+	snapshotsDir   = "snapshots"       //a directory for each snapshot
+	byDateDir      = "by-date"         //yyyy/mm/dd with the last snapshot of each day
+	snapshotLayout = "2006-01-02 1504" //names of the snapshots in snapshotsDir
+	allowed        = "OPTIONS, GET, HEAD, PROPFIND"
+)
+
+var errNotFound = errors.New("not found")
+
+//A resource is a file or directory served, either in a snapshot or virtual
+type resource struct {
+	name    string
+	dir     bool
+	size    int64
+	modTime time.Time
+	path    string //in the dump, "" for the virtual directories
+	snap    *dnav.Snapshot
+	rel     string
+
+	children []*resource //of the virtual directories
+}
+
+//dav serves the dump read only over WebDAV, with the snapshots
+//in /snapshots and by day in /by-date
+type dav struct {
+	roots  dnav.Roots
+	nProcs int
+}
+
+func newDav(roots dnav.Roots, nProcs int) *dav {
+	return &dav{roots, nProcs}
+}
+
+func virtual(name string, t time.Time) *resource {
+	return &resource{name: name, dir: true, modTime: t}
+}
+
+//inSnapshot is the resource for a path relative to the main root in a snapshot
+func (d *dav) inSnapshot(name string, s *dnav.Snapshot, rel string) (*resource, error) {
+	p, fi, err := s.Resolve(rel, d.roots)
+	if err != nil {
+		return nil, errNotFound
+	}
+	return &resource{name: name, dir: fi.IsDir(), size: fi.Size(), modTime: fi.ModTime(), path: p, snap: s, rel: rel}, nil
+}
+
+//lastOfDay returns the index of the last snapshot for each day
+func lastOfDay(snaps []dnav.Snapshot) (days map[string]int) {
+	days = make(map[string]int)
+	for i := range snaps {
+		days[snaps[i].Date.Time().Format("2006/01/02")] = i
+	}
+	return days
+}
+
+//find resolves a url path into a resource, with the children of the virtual directories
+func (d *dav) find(urlPath string) (r *resource, err error) {
+	els := strings.Split(strings.Trim(path.Clean("/"+urlPath), "/"), "/")
+	if els[0] == "" {
+		els = nil
+	}
+	rest := func(n int) string {
+		if len(els) <= n {
+			return ""
+		}
+		return "/" + strings.Join(els[n:], "/")
+	}
+	//a path in a snapshot only needs to find that snapshot, not to list the dump
+	switch {
+	case len(els) >= 2 && els[0] == snapshotsDir:
+		t, err := time.ParseInLocation(snapshotLayout, els[1], time.Local)
+		if err != nil {
+			return nil, errNotFound
+		}
+		s, err := dnav.FindSnapshot(dnav.TInDumpDate(t), d.roots)
+		if err != nil || s.Date != dnav.TInDumpDate(t) {
+			return nil, errNotFound
+		}
+		return d.inSnapshot(path.Base("/"+urlPath), &s, rest(2))
+	case len(els) >= 4 && els[0] == byDateDir:
+		day := strings.Join(els[1:4], "/")
+		t, err := time.ParseInLocation("2006/01/02", day, time.Local)
+		if err != nil {
+			return nil, errNotFound
+		}
+		end := time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 0, 0, time.Local)
+		s, err := dnav.FindSnapshot(dnav.TInDumpDate(end), d.roots)
+		if err != nil || s.Date.Time().Format("2006/01/02") != day {
+			return nil, errNotFound
+		}
+		return d.inSnapshot(els[len(els)-1], &s, rest(4))
+	}
+
+	snaps, err := dnav.ListSnapshots(d.roots, dnav.DumpDate{}, d.nProcs)
+	if err != nil {
+		return nil, err
+	}
+	var newest time.Time
+	if len(snaps) > 0 {
+		newest = snaps[len(snaps)-1].Date.Time()
+	}
+	switch {
+	case len(els) == 0:
+		r = virtual("", newest)
+		r.children = []*resource{virtual(snapshotsDir, newest), virtual(byDateDir, newest)}
+		return r, nil
+	case els[0] == snapshotsDir && len(els) == 1:
+		r = virtual(snapshotsDir, newest)
+		for i := range snaps {
+			c, err := d.inSnapshot(snaps[i].Date.Time().Format(snapshotLayout), &snaps[i], "")
+			if err == nil {
+				r.children = append(r.children, c)
+			}
+		}
+		return r, nil
+	case els[0] == byDateDir:
+		//the years, months or days with snapshots
+		days := lastOfDay(snaps)
+		prefix := strings.Join(els[1:], "/")
+		r = virtual(els[len(els)-1], newest)
+		seen := make(map[string]bool)
+		for day := range days {
+			if prefix != "" && !strings.HasPrefix(day, prefix+"/") {
+				continue
+			}
+			name := strings.Split(day, "/")[len(els)-1]
+			if !seen[name] {
+				seen[name] = true
+				r.children = append(r.children, virtual(name, newest))
+			}
+		}
+		if len(r.children) == 0 && prefix != "" {
+			return nil, errNotFound
+		}
+		sort.Slice(r.children, func(i, j int) bool {
+			return r.children[i].name < r.children[j].name
+		})
+		return r, nil
+	}
+	return nil, errNotFound
+}
+
+//list returns the children of a directory
+func (d *dav) list(r *resource) (children []*resource, err error) {
+	if r.path == "" {
+		return r.children, nil
+	}
+	files, err := ioutil.ReadDir(r.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		c, err := d.inSnapshot(f.Name(), r.snap, r.rel+"/"+f.Name())
+		if err != nil {
+			continue //out of the snapshot or broken symbolic link
+		}
+		children = append(children, c)
+	}
+	return children, nil
+}
+
+type multistatus struct {
+	XMLName   xml.Name   `xml:"D:multistatus"`
+	XmlnsD    string     `xml:"xmlns:D,attr"`
+	Responses []response `xml:"D:response"`
+}
+
+type response struct {
+	Href     string   `xml:"D:href"`
+	Propstat propstat `xml:"D:propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"D:prop"`
+	Status string `xml:"D:status"`
+}
+
+type prop struct {
+	DisplayName   string       `xml:"D:displayname"`
+	ResourceType  resourceType `xml:"D:resourcetype"`
+	ContentLength string       `xml:"D:getcontentlength,omitempty"`
+	ContentType   string       `xml:"D:getcontenttype,omitempty"`
+	LastModified  string       `xml:"D:getlastmodified"`
+	ETag          string       `xml:"D:getetag,omitempty"`
+}
+
+type resourceType struct {
+	Collection *struct{} `xml:"D:collection"`
+}
+
+func (r *resource) response(href string) response {
+	p := prop{
+		DisplayName:  r.name,
+		LastModified: r.modTime.UTC().Format(http.TimeFormat),
+	}
+	if r.dir {
+		p.ResourceType.Collection = &struct{}{}
+		if !strings.HasSuffix(href, "/") {
+			href += "/"
+		}
+	} else {
+		p.ContentLength = strconv.FormatInt(r.size, 10)
+		p.ContentType = mime.TypeByExtension(filepath.Ext(r.name))
+		if p.ContentType == "" {
+			p.ContentType = "application/octet-stream"
+		}
+		p.ETag = fmt.Sprintf(`"%x-%x"`, r.modTime.UnixNano(), r.size)
+	}
+	return response{Href: (&url.URL{Path: href}).EscapedPath(), Propstat: propstat{p, "HTTP/1.1 200 OK"}}
+}
+
+func (d *dav) propfind(w http.ResponseWriter, req *http.Request, r *resource) {
+	depth := req.Header.Get("Depth")
+	if depth == "infinity" || depth == "" {
+		http.Error(w, "propfind-finite-depth", http.StatusForbidden)
+		return
+	}
+	ms := multistatus{XmlnsD: "DAV:"}
+	ms.Responses = append(ms.Responses, r.response(req.URL.Path))
+	if depth == "1" && r.dir {
+		children, err := d.list(r)
+		if err != nil {
+			d.error(w, err)
+			return
+		}
+		for _, c := range children {
+			ms.Responses = append(ms.Responses, c.response(path.Join(req.URL.Path, c.name)))
+		}
+	}
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprint(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(&ms); err != nil {
+		Dprintf("propfind: %s\n", err)
+	}
+}
+
+func (d *dav) get(w http.ResponseWriter, req *http.Request, r *resource) {
+	if r.dir {
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			http.Redirect(w, req, (&url.URL{Path: req.URL.Path + "/"}).EscapedPath(), http.StatusMovedPermanently)
+			return
+		}
+		children, err := d.list(r)
+		if err != nil {
+			d.error(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<html><body><ul>\n")
+		for _, c := range children {
+			name := c.name
+			if c.dir {
+				name += "/"
+			}
+			href := (&url.URL{Path: name}).EscapedPath()
+			fmt.Fprintf(w, "<li><a href=\"./%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+		}
+		fmt.Fprintf(w, "</ul></body></html>\n")
+		return
+	}
+	f, err := os.Open(r.path)
+	if err != nil {
+		d.error(w, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, req, r.name, r.modTime, f)
+}
+
+func (d *dav) error(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if err == errNotFound || os.IsNotExist(err) {
+		status = http.StatusNotFound
+	}
+	Dprintf("error %d: %s\n", status, err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (d *dav) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	Dprintf("%s %s\n", req.Method, req.URL)
+	w.Header().Set("DAV", "1")
+	w.Header().Set("Allow", allowed)
+	switch req.Method {
+	case "OPTIONS":
+		w.Header().Set("MS-Author-Via", "DAV")
+		return
+	case "GET", "HEAD", "PROPFIND":
+	default:
+		http.Error(w, "read only", http.StatusMethodNotAllowed)
+		return
+	}
+	r, err := d.find(req.URL.Path)
+	if err != nil {
+		d.error(w, err)
+		return
+	}
+	if req.Method == "PROPFIND" {
+		d.propfind(w, req, r)
+		return
+	}
+	d.get(w, req, r)
+}
+
+func main() {
+	var roots dnav.Roots
+
+	rdFlags()
+	if len(flag.Args()) != 0 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	log.Printf("serving %s on http://%s/", roots.DumpRoot, addr)
+	log.Fatal(http.ListenAndServe(addr, newDav(roots, nProcs)))
+}
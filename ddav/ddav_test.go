@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+//mkServer takes a snapshot on two days and twice on the last one,
+//with a file changed each time, and serves the dump
+func mkServer(t *testing.T) *httptest.Server {
+	var roots dnav.Roots
+	tmp := t.TempDir()
+	live := tmp + "/ROOT"
+	os.MkdirAll(live+"/a", 0755)
+	os.MkdirAll(tmp+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmp+"/dump")
+	dnav.RdRoots(&roots)
+
+	os.WriteFile(tmp+"/secret", []byte("secret\n"), 0644)
+	os.Symlink(tmp+"/secret", live+"/a/out")
+	t1 := time.Date(2017, 5, 10, 16, 5, 0, 0, time.Local)
+	for i, d := range []time.Duration{0, 24 * time.Hour, 26 * time.Hour} {
+		os.WriteFile(live+"/a/f.txt", []byte(strings.Repeat("x", i+1)), 0644)
+		if _, _, err := dnav.TakeSnapshot(roots, t1.Add(d), nil); err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+	}
+	srv := httptest.NewServer(newDav(roots, 2))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func do(t *testing.T, srv *httptest.Server, method string, url string, depth string, status int) (resp *http.Response, body string) {
+	req, err := http.NewRequest(method, srv.URL+url, nil)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, url, err)
+	}
+	if depth != "" {
+		req.Header.Set("Depth", depth)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, url, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, url, err)
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status %d, should be %d", method, url, resp.StatusCode, status)
+	}
+	return resp, string(b)
+}
+
+type davResponse struct {
+	Href   string    `xml:"href"`
+	Name   string    `xml:"propstat>prop>displayname"`
+	Length string    `xml:"propstat>prop>getcontentlength"`
+	Coll   *struct{} `xml:"propstat>prop>resourcetype>collection"`
+}
+
+//propfind returns the responses of a PROPFIND of depth 1
+func propfind(t *testing.T, srv *httptest.Server, url string) (rs []davResponse) {
+	_, body := do(t, srv, "PROPFIND", url, "1", http.StatusMultiStatus)
+	var ms struct {
+		Responses []davResponse `xml:"response"`
+	}
+	if err := xml.Unmarshal([]byte(body), &ms); err != nil {
+		t.Fatalf("propfind %s: %s", url, err)
+	}
+	return ms.Responses
+}
+
+func hrefs(rs []davResponse) string {
+	var hs []string
+	for _, r := range rs {
+		hs = append(hs, r.Href)
+	}
+	return strings.Join(hs, " ")
+}
+
+func TestPropfind(t *testing.T) {
+	srv := mkServer(t)
+	if hs := hrefs(propfind(t, srv, "/")); hs != "/ /snapshots/ /by-date/" {
+		t.Fatalf("bad root %s", hs)
+	}
+	rs := propfind(t, srv, "/snapshots")
+	if hs := hrefs(rs); hs != "/snapshots/ /snapshots/2017-05-10%201605/ /snapshots/2017-05-11%201605/ /snapshots/2017-05-11%201805/" {
+		t.Fatalf("bad snapshots %s", hs)
+	}
+	if rs[1].Name != "2017-05-10 1605" || rs[1].Coll == nil {
+		t.Fatalf("bad snapshot %v", rs[1])
+	}
+	if hs := hrefs(propfind(t, srv, "/by-date/")); hs != "/by-date/ /by-date/2017/" {
+		t.Fatalf("bad years %s", hs)
+	}
+	if hs := hrefs(propfind(t, srv, "/by-date/2017/05/")); hs != "/by-date/2017/05/ /by-date/2017/05/10/ /by-date/2017/05/11/" {
+		t.Fatalf("bad days %s", hs)
+	}
+	rs = propfind(t, srv, "/by-date/2017/05/11/a/")
+	if hs := hrefs(rs); hs != "/by-date/2017/05/11/a/ /by-date/2017/05/11/a/f.txt" {
+		t.Fatalf("symbolic links out of the snapshot should not be listed: %s", hs)
+	}
+	if rs[1].Length != "3" || rs[1].Coll != nil {
+		t.Fatalf("the day should be its last snapshot: %v", rs[1])
+	}
+	do(t, srv, "PROPFIND", "/", "infinity", http.StatusForbidden)
+	do(t, srv, "PROPFIND", "/by-date/2017/06/", "1", http.StatusNotFound)
+	do(t, srv, "PROPFIND", "/snapshots/2017-05-10 1606/", "1", http.StatusNotFound)
+	do(t, srv, "PROPFIND", "/by-date/2017/05/12/a/", "1", http.StatusNotFound)
+}
+
+func TestGet(t *testing.T) {
+	srv := mkServer(t)
+	resp, _ := do(t, srv, "OPTIONS", "/", "", http.StatusOK)
+	if resp.Header.Get("DAV") != "1" || strings.Contains(resp.Header.Get("Allow"), "PUT") {
+		t.Fatalf("bad options %v", resp.Header)
+	}
+	if _, body := do(t, srv, "GET", "/snapshots/2017-05-10%201605/a/f.txt", "", http.StatusOK); body != "x" {
+		t.Fatalf("bad file %q", body)
+	}
+	if _, body := do(t, srv, "GET", "/by-date/2017/05/10/a/f.txt", "", http.StatusOK); body != "x" {
+		t.Fatalf("bad file %q", body)
+	}
+	if _, body := do(t, srv, "GET", "/by-date/2017/05/11/a/", "", http.StatusOK); !strings.Contains(body, "f.txt") {
+		t.Fatalf("bad listing %q", body)
+	}
+	do(t, srv, "GET", "/by-date/2017/05/11/a/out", "", http.StatusNotFound)
+	do(t, srv, "GET", "/by-date/2017/05/11/../../../../secret", "", http.StatusNotFound)
+	for _, m := range []string{"PUT", "DELETE", "MKCOL", "MOVE", "COPY", "PROPPATCH", "LOCK"} {
+		do(t, srv, m, "/by-date/2017/05/11/a/f.txt", "", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug       bool
+	verbose     bool
+	diffFlag    bool
+	summaryFlag bool
+	maxDiffSize int64
+	prunes      []string
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	v := flag.Bool("v", false, "verbose flag")
+	d := flag.Bool("d", false, "print the diffs of the modified text files")
+	s := flag.Bool("s", false, "summary per top level directory")
+	z := flag.Int64("z", dnav.DefMaxSize, "max size in bytes of files to diff")
+	x := flag.String("x", "", "comma separated names of directories not to descend into")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	verbose = *v
+	diffFlag = *d
+	summaryFlag = *s
+	maxDiffSize = *z
+	if *x != "" {
+		prunes = strings.Split(*x, ",")
+	}
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "ddiff: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("ddiff [-Dvds] [-z=maxDiffSize] [-x=dir,dir...] date1 date2 [path]")
+}
+
+//kinds of changes, named as in hist(1)
+const (
+	same = iota
+	create
+	delete
+	write
+	wstat
+	nKinds
+)
+
+var kindNames = []string{"same", "create", "delete", "write", "wstat"}
+
+//scan finds all the files under rel in the snapshot
+func scan(s *dnav.Snapshot, roots dnav.Roots, rel string) (files map[string]os.FileInfo, err error) {
+	files = make(map[string]os.FileInfo)
+	err = dnav.WalkSnapshot(s, roots, rel, dnav.Prune(prunes), func(r string, fi os.FileInfo) error {
+		files[r] = fi
+		return nil
+	})
+	return files, err
+}
+
+//compare finds how a file changed between the two snapshots, hashing
+//the contents only if they cannot be told apart otherwise
+func compare(p string, fi os.FileInfo, p2 string, fi2 os.FileInfo) (kind int, err error) {
+	perm := fi.Mode() != fi2.Mode()
+	switch {
+	case fi.IsDir():
+		if perm {
+			return wstat, nil
+		}
+		return same, nil
+	case fi.Mode()&os.ModeSymlink != 0:
+		l, err := os.Readlink(p)
+		if err != nil {
+			return same, err
+		}
+		l2, err := os.Readlink(p2)
+		if err != nil {
+			return same, err
+		}
+		if l != l2 {
+			return write, nil
+		}
+		return same, nil
+	case !fi.Mode().IsRegular():
+		if perm {
+			return wstat, nil
+		}
+		return same, nil
+	}
+	if dnav.SameInfo(fi, fi2) {
+		if perm {
+			return wstat, nil
+		}
+		return same, nil
+	}
+	if fi.Size() != fi2.Size() {
+		return write, nil
+	}
+	sum, err := dnav.HashFile(p)
+	if err != nil {
+		return same, err
+	}
+	sum2, err := dnav.HashFile(p2)
+	if err != nil {
+		return same, err
+	}
+	if sum != sum2 {
+		return write, nil
+	}
+	return wstat, nil
+}
+
+func isText(txt string) bool {
+	return !strings.ContainsRune(txt, utf8.RuneError)
+}
+
+//printDiff prints the diffs of two versions of a text file, as hist(1) does
+func printDiff(p string, fi os.FileInfo, p2 string, fi2 os.FileInfo) error {
+	if !fi.Mode().IsRegular() || fi.Size() > maxDiffSize || fi2.Size() > maxDiffSize {
+		return nil
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	b2, err := ioutil.ReadFile(p2)
+	if err != nil {
+		return err
+	}
+	txt, txt2 := string(b), string(b2)
+	if !isText(txt) || !isText(txt2) {
+		return nil
+	}
+	fmt.Println(dnav.Diff(p, txt, p2, txt2))
+	return nil
+}
+
+func fmtInfo(fi os.FileInfo) string {
+	return fmt.Sprintf("%d %#o %v", fi.Size(), fi.Mode(), fi.ModTime())
+}
+
+//counts are the changes in a top level directory for the summary
+type counts struct {
+	n     [nKinds]int
+	bytes [nKinds]int64
+}
+
+func printSummary(sum map[string]*counts) {
+	dirs := make([]string, 0, len(sum))
+	var total counts
+	for d, c := range sum {
+		dirs = append(dirs, d)
+		for k := 0; k < nKinds; k++ {
+			total.n[k] += c.n[k]
+			total.bytes[k] += c.bytes[k]
+		}
+	}
+	sort.Strings(dirs)
+	fmt.Printf("dir\tcreate\tbytes\tdelete\tbytes\twrite\tbytes\twstat\n")
+	pr := func(name string, c *counts) {
+		fmt.Printf("%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", name, c.n[create], c.bytes[create],
+			c.n[delete], c.bytes[delete], c.n[write], c.bytes[write], c.n[wstat])
+	}
+	for _, d := range dirs {
+		pr(d, sum[d])
+	}
+	pr("total", &total)
+}
+
+//ddiff compares the tree under rel in both snapshots and reports if they differ
+func ddiff(s *dnav.Snapshot, s2 *dnav.Snapshot, roots dnav.Roots, rel string) (differ bool) {
+	files, err := scan(s, roots, rel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	files2, err := scan(s2, roots, rel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var paths []string
+	for r := range files {
+		paths = append(paths, r)
+	}
+	for r := range files2 {
+		if _, ok := files[r]; !ok {
+			paths = append(paths, r)
+		}
+	}
+	sort.Strings(paths)
+
+	summary := make(map[string]*counts)
+	report := func(kind int, r string, fi os.FileInfo) {
+		differ = true
+		if summaryFlag {
+			top := dnav.TopDir(r, rel)
+			c := summary[top]
+			if c == nil {
+				c = &counts{}
+				summary[top] = c
+			}
+			c.n[kind]++
+			if fi.Mode().IsRegular() {
+				c.bytes[kind] += fi.Size()
+			}
+			return
+		}
+		if verbose {
+			fmt.Printf("#%s\t%s\t%s\n", kindNames[kind], dnav.LivePath(r, roots), fmtInfo(fi))
+		} else {
+			fmt.Printf("#%s\t%s\n", kindNames[kind], dnav.LivePath(r, roots))
+		}
+	}
+	for _, r := range paths {
+		fi, fi2 := files[r], files2[r]
+		p, p2 := s.PathOf(r, roots), s2.PathOf(r, roots)
+		switch {
+		case fi2 == nil:
+			report(delete, r, fi)
+		case fi == nil:
+			report(create, r, fi2)
+		case fi.Mode().Type() != fi2.Mode().Type():
+			report(delete, r, fi)
+			report(create, r, fi2)
+		default:
+			kind, err := compare(p, fi, p2, fi2)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ddiff: %s\n", err)
+				continue
+			}
+			if kind == same {
+				continue
+			}
+			report(kind, r, fi2)
+			if kind == write && diffFlag && !summaryFlag {
+				if err := printDiff(p, fi, p2, fi2); err != nil {
+					fmt.Fprintf(os.Stderr, "ddiff: %s\n", err)
+				}
+			}
+		}
+	}
+	if summaryFlag {
+		printSummary(summary)
+	}
+	return differ
+}
+
+//findSnapshot finds the snapshot for a date given by the user
+func findSnapshot(s string, roots dnav.Roots) (snap dnav.Snapshot) {
+	d, err := dnav.ParseDateEnd(s, roots)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if snap, err = dnav.FindSnapshot(d, roots); err != nil {
+		log.Fatal(err)
+	}
+	Dprintf("%s is %s\n", s, snap.Path)
+	return snap
+}
+
+func main() {
+	var (
+		roots dnav.Roots
+		rel   string
+	)
+
+	rdFlags()
+	args := flag.Args()
+	if len(args) != 2 && len(args) != 3 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	s := findSnapshot(args[0], roots)
+	s2 := findSnapshot(args[1], roots)
+	if len(args) == 3 {
+		path, err := filepath.Abs(args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if rel, err = dnav.RelPath(path, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if ddiff(&s, &s2, roots, rel) {
+		os.Exit(1)
+	}
+}
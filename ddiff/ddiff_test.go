@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+//output runs f and returns what it prints
+func output(t *testing.T, f func()) string {
+	out, err := ioutil.TempFile(t.TempDir(), "out")
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+	f()
+	b, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	return string(b)
+}
+
+func TestDdiff(t *testing.T) {
+	var roots dnav.Roots
+	tmp := t.TempDir()
+	live := tmp + "/ROOT"
+	os.MkdirAll(live+"/src", 0755)
+	os.MkdirAll(tmp+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmp+"/dump")
+	dnav.RdRoots(&roots)
+	maxDiffSize = dnav.DefMaxSize
+
+	t1 := time.Date(2017, 5, 10, 16, 5, 0, 0, time.Local)
+	os.WriteFile(live+"/a.txt", []byte("one\ntwo\n"), 0644)
+	os.WriteFile(live+"/src/b.txt", []byte("two\n"), 0644)
+	os.WriteFile(live+"/src/c.txt", []byte("three\n"), 0644)
+	s, _, err := dnav.TakeSnapshot(roots, t1, nil)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	os.WriteFile(live+"/a.txt", []byte("one\n2\n"), 0644)
+	os.Remove(live + "/src/b.txt")
+	os.Chmod(live+"/src/c.txt", 0600)
+	os.WriteFile(live+"/src/d.txt", []byte("four\n"), 0644)
+	s2, _, err := dnav.TakeSnapshot(roots, t1.Add(24*time.Hour), nil)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+
+	var differ bool
+	out := output(t, func() { differ = ddiff(&s, &s, roots, "") })
+	if differ || out != "" {
+		t.Errorf("should find no changes against itself: %q", out)
+	}
+	out = output(t, func() { differ = ddiff(&s, &s2, roots, "") })
+	want := "#write\t" + live + "/a.txt\n" +
+		"#delete\t" + live + "/src/b.txt\n" +
+		"#wstat\t" + live + "/src/c.txt\n" +
+		"#create\t" + live + "/src/d.txt\n"
+	if !differ || out != want {
+		t.Errorf("bad changes, got:\n%s\nwant:\n%s", out, want)
+	}
+	out = output(t, func() { ddiff(&s2, &s, roots, "/src") })
+	want = "#create\t" + live + "/src/b.txt\n" +
+		"#wstat\t" + live + "/src/c.txt\n" +
+		"#delete\t" + live + "/src/d.txt\n"
+	if out != want {
+		t.Errorf("bad changes under /src, got:\n%s\nwant:\n%s", out, want)
+	}
+
+	diffFlag = true
+	out = output(t, func() { ddiff(&s, &s2, roots, "") })
+	diffFlag = false
+	if !strings.Contains(out, "<two\n") || !strings.Contains(out, ">2\n") {
+		t.Errorf("should print the diff of the written file:\n%s", out)
+	}
+	summaryFlag = true
+	out = output(t, func() { ddiff(&s, &s2, roots, "") })
+	summaryFlag = false
+	if !strings.Contains(out, "/src\t1\t5\t1\t4\t0\t0\t1\n") || !strings.Contains(out, "total\t1\t5\t1\t4\t1\t6\t1\n") {
+		t.Errorf("bad summary:\n%s", out)
+	}
+}
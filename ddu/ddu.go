@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug    bool
+	dirsFlag bool
+	hashFlag bool
+	fromStr  string
+	untilStr string
+	prunes   []string
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	d := flag.Bool("d", false, "growth per top level directory")
+	h := flag.Bool("H", false, "hash the files to find if they are identical to the previous dump")
+	s := flag.String("s", "", "earliest date or dump path")
+	e := flag.String("e", "", "latest date or dump path")
+	x := flag.String("x", "", "comma separated names of directories not to descend into")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	dirsFlag = *d
+	hashFlag = *h
+	fromStr = *s
+	untilStr = *e
+	if *x != "" {
+		prunes = strings.Split(*x, ",")
+	}
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "ddu: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("ddu [-DdH] [-s=earliest] [-e=latest] [-x=dir,dir...] [path]")
+}
+
+//usage of a snapshot or of a directory in it
+type space struct {
+	size   int64 //logical size
+	unique int64 //not in any previous snapshot
+}
+
+//version is what a path was in the previous snapshot
+type version struct {
+	fi   os.FileInfo
+	path string
+}
+
+//accounter keeps what has been seen in the previous snapshots
+type accounter struct {
+	roots dnav.Roots
+	rel   string
+	ids   map[dnav.FileID]bool
+	prev  map[string]version
+}
+
+//identical finds if a file is identical to the one with the same
+//path in the previous snapshot
+func (a *accounter) identical(r string, p string, fi os.FileInfo) bool {
+	v, ok := a.prev[r]
+	if !ok || v.fi.Size() != fi.Size() {
+		return false
+	}
+	if dnav.SameInfo(v.fi, fi) {
+		return true
+	}
+	if !hashFlag {
+		return false
+	}
+	sum, err := dnav.HashFile(v.path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ddu: %s\n", err)
+		return false
+	}
+	sum2, err := dnav.HashFile(p)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ddu: %s\n", err)
+		return false
+	}
+	return sum == sum2
+}
+
+//account walks a snapshot, the snapshots have to be accounted in order
+func (a *accounter) account(s *dnav.Snapshot) (total space, dirs map[string]*space) {
+	dirs = make(map[string]*space)
+	cur := make(map[string]version)
+	err := dnav.WalkSnapshot(s, a.roots, a.rel, dnav.Prune(prunes), func(r string, fi os.FileInfo) error {
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		p := s.PathOf(r, a.roots)
+		cur[r] = version{fi, p}
+		top := dnav.TopDir(r, a.rel)
+		d := dirs[top]
+		if d == nil {
+			d = &space{}
+			dirs[top] = d
+		}
+		d.size += fi.Size()
+		total.size += fi.Size()
+
+		id, ok := dnav.FileIDOf(fi)
+		if ok && a.ids[id] {
+			return nil
+		}
+		if ok {
+			a.ids[id] = true
+		}
+		if a.identical(r, p, fi) {
+			return nil
+		}
+		d.unique += fi.Size()
+		total.unique += fi.Size()
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ddu: %s: %s\n", s.Path, err)
+	}
+	a.prev = cur
+	return total, dirs
+}
+
+func main() {
+	var (
+		roots       dnav.Roots
+		from, until dnav.DumpDate
+		rel         string
+		err         error
+	)
+
+	rdFlags()
+	args := flag.Args()
+	if len(args) > 1 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	if len(args) == 1 {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if rel, err = dnav.RelPath(path, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if fromStr != "" {
+		if from, err = dnav.ParseDate(fromStr, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if untilStr != "" {
+		if until, err = dnav.ParseDateEnd(untilStr, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	snaps, err := dnav.ListSnapshots(roots, from, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	snaps = dnav.Until(snaps, until)
+	if len(snaps) == 0 {
+		log.Fatal("no dumps")
+	}
+
+	a := &accounter{roots: roots, rel: rel, ids: make(map[dnav.FileID]bool)}
+	var sum space
+	prevDirs := make(map[string]*space)
+	if dirsFlag {
+		fmt.Printf("dump\tdir\tsize\tunique\tgrowth\n")
+	} else {
+		fmt.Printf("dump\tsize\tunique\n")
+	}
+	for i := range snaps {
+		s := &snaps[i]
+		total, dirs := a.account(s)
+		sum.unique += total.unique
+		if !dirsFlag {
+			fmt.Printf("%s\t%d\t%d\n", s.Date.Name(), total.size, total.unique)
+			continue
+		}
+		names := make([]string, 0, len(dirs))
+		for d := range dirs {
+			names = append(names, d)
+		}
+		for d := range prevDirs {
+			if dirs[d] == nil {
+				names = append(names, d)
+			}
+		}
+		sort.Strings(names)
+		for _, d := range names {
+			cur := dirs[d]
+			if cur == nil {
+				cur = &space{} //gone, printed only once
+			}
+			growth := cur.size
+			if prev, ok := prevDirs[d]; ok {
+				growth -= prev.size
+			}
+			fmt.Printf("%s\t%s\t%d\t%d\t%+d\n", s.Date.Name(), d, cur.size, cur.unique, growth)
+		}
+		prevDirs = dirs
+	}
+	fmt.Printf("total\t\t%d\n", sum.unique)
+}
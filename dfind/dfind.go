@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug    bool
+	reFlag   bool
+	nProcs   int
+	fromStr  string
+	untilStr string
+	prunes   []string
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	r := flag.Bool("r", false, "the pattern is a regular expression")
+	p := flag.Int("p", runtime.NumCPU(), "# of snapshots searched concurrently")
+	s := flag.String("s", "", "earliest date or dump path")
+	e := flag.String("e", "", "latest date or dump path")
+	x := flag.String("x", "", "comma separated names of directories not to descend into")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	reFlag = *r
+	nProcs = *p
+	if nProcs < 1 {
+		nProcs = 1
+	}
+	fromStr = *s
+	untilStr = *e
+	if *x != "" {
+		prunes = strings.Split(*x, ",")
+	}
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dfind: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dfind [-Dr] [-p=nprocs] [-s=earliest] [-e=latest] [-x=dir,dir...] pattern [path]")
+}
+
+//a matcher finds if a path relative to the main root is the one looked for
+type matcher func(rel string) bool
+
+//newMatcher makes a matcher from a regular expression matching anywhere in the
+//path or from a glob matching the name or, if it has slashes, the whole path
+func newMatcher(pat string) (matcher, error) {
+	if reFlag {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	if _, err := filepath.Match(pat, ""); err != nil {
+		return nil, err
+	}
+	if strings.Contains(pat, "/") {
+		return func(rel string) bool {
+			ok, _ := filepath.Match(pat, rel)
+			return ok
+		}, nil
+	}
+	return func(rel string) bool {
+		ok, _ := filepath.Match(pat, filepath.Base(rel))
+		return ok
+	}, nil
+}
+
+//seen records the first and last snapshots (indexes) in which a path is found
+type seen struct {
+	first int
+	last  int
+}
+
+//find searches the snapshots concurrently for the paths under rel matching m
+func find(snaps []dnav.Snapshot, roots dnav.Roots, rel string, m matcher) map[string]*seen {
+	found := make(map[string]*seen)
+	var lk sync.Mutex
+	err := dnav.WalkSnapshots(snaps, roots, rel, dnav.Prune(prunes), nProcs, func(i int, r string, fi os.FileInfo) error {
+		if !m(r) {
+			return nil
+		}
+		lk.Lock()
+		defer lk.Unlock()
+		s := found[r]
+		if s == nil {
+			found[r] = &seen{i, i}
+			return nil
+		}
+		if i < s.first {
+			s.first = i
+		}
+		if i > s.last {
+			s.last = i
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dfind: %s\n", err)
+	}
+	return found
+}
+
+func main() {
+	var (
+		roots       dnav.Roots
+		from, until dnav.DumpDate
+		rel         string
+		err         error
+	)
+
+	rdFlags()
+	args := flag.Args()
+	if len(args) != 1 && len(args) != 2 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	m, err := newMatcher(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) == 2 {
+		path, err := filepath.Abs(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if rel, err = dnav.RelPath(path, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if fromStr != "" {
+		if from, err = dnav.ParseDate(fromStr, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if untilStr != "" {
+		if until, err = dnav.ParseDateEnd(untilStr, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	Dprintf("from %s until %s rel %s\n", &from, &until, rel)
+
+	snaps, err := dnav.ListSnapshots(roots, from, nProcs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	snaps = dnav.Until(snaps, until)
+	if len(snaps) == 0 {
+		log.Fatal("no dumps")
+	}
+
+	found := find(snaps, roots, rel, m)
+	paths := make([]string, 0, len(found))
+	for p := range found {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		s := found[p]
+		fmt.Printf("%s\t%s\t%s\n", dnav.LivePath(p, roots), snaps[s.first].Date.Name(), snaps[s.last].Date.Name())
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug   bool
+	addr    string
+	nProcs  int
+	refresh time.Duration
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	a := flag.String("a", "localhost:5640", "address to listen to, a path for a unix socket")
+	p := flag.Int("p", runtime.NumCPU(), "number of dumps to look up in parallel")
+	r := flag.Duration("r", time.Minute, "how often the list of dumps is refreshed")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	addr = *a
+	nProcs = *p
+	refresh = *r
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dfs: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dfs [-D] [-a=addr] [-p=nprocs] [-r=refresh]")
+}
+
+var errReadOnly = errors.New("read only file system")
+
+//A fid is a file in use by the client
+type fid struct {
+	n    *node
+	open bool
+	f    *os.File //for the open files of the dump
+
+	dir    []byte //the stats of the children of an open directory,
+	dirOff []int  //where each starts
+}
+
+//conn serves a connection to a client, its messages are
+//served in order, one at a time
+type conn struct {
+	tr    *tree
+	rw    io.ReadWriter
+	msize uint32
+	fids  map[uint32]*fid
+}
+
+func serve(tr *tree, rw io.ReadWriter) error {
+	c := &conn{tr: tr, rw: rw, msize: maxMsg, fids: make(map[uint32]*fid)}
+	defer c.clunkAll()
+	w := bufio.NewWriter(rw)
+	for {
+		t, err := readMsg(rw, c.msize)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		r := c.rpc(t)
+		r.Tag = t.Tag
+		if _, err := w.Write(pack(r)); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *conn) clunkAll() {
+	for id, f := range c.fids {
+		if f.f != nil {
+			f.f.Close()
+		}
+		delete(c.fids, id)
+	}
+}
+
+func rerror(err error) *Fcall {
+	return &Fcall{Type: Rerror, Ename: err.Error()}
+}
+
+//rpc answers a message
+func (c *conn) rpc(t *Fcall) *Fcall {
+	Dprintf("<- %s\n", t)
+	if t.Type == Tversion {
+		return c.version(t)
+	}
+	if t.Type == Tattach {
+		return c.attach(t)
+	}
+	if t.Type == Tflush {
+		return &Fcall{Type: Rflush} //messages are answered in order, it is done
+	}
+	if t.Type == Tauth {
+		return rerror(errors.New("no authentication required"))
+	}
+	f, ok := c.fids[t.Fid]
+	if !ok {
+		return rerror(errors.New("unknown fid"))
+	}
+	switch t.Type {
+	case Twalk:
+		return c.walk(f, t)
+	case Topen:
+		return c.open(f, t)
+	case Tread:
+		return c.read(f, t)
+	case Tclunk, Tremove:
+		if f.f != nil {
+			f.f.Close()
+		}
+		delete(c.fids, t.Fid)
+		if t.Type == Tremove {
+			return rerror(errReadOnly)
+		}
+		return &Fcall{Type: Rclunk}
+	case Tstat:
+		return &Fcall{Type: Rstat, Stat: packDir(c.tr.stat(f.n))}
+	case Tcreate, Twrite, Twstat:
+		return rerror(errReadOnly)
+	}
+	return rerror(errors.New("bad message"))
+}
+
+func (c *conn) version(t *Fcall) *Fcall {
+	c.clunkAll()
+	if t.Msize < ioHdrSz+64 {
+		return rerror(errors.New("msize too small"))
+	}
+	if t.Msize < c.msize {
+		c.msize = t.Msize
+	}
+	v := version
+	if !strings.HasPrefix(t.Version, version) {
+		v = "unknown"
+	}
+	return &Fcall{Type: Rversion, Msize: c.msize, Version: v}
+}
+
+func (c *conn) attach(t *Fcall) *Fcall {
+	if t.Afid != noFid {
+		return rerror(errors.New("no authentication required"))
+	}
+	if _, ok := c.fids[t.Fid]; ok {
+		return rerror(errors.New("fid in use"))
+	}
+	n := c.tr.root()
+	c.fids[t.Fid] = &fid{n: n}
+	return &Fcall{Type: Rattach, Qid: n.qid()}
+}
+
+func (c *conn) walk(f *fid, t *Fcall) *Fcall {
+	if f.open {
+		return rerror(errors.New("walk of an open file"))
+	}
+	if _, ok := c.fids[t.Newfid]; ok && t.Newfid != t.Fid {
+		return rerror(errors.New("fid in use"))
+	}
+	n := f.n
+	r := &Fcall{Type: Rwalk}
+	for _, name := range t.Wname {
+		child, err := c.tr.walk(n, name)
+		if err != nil {
+			if len(r.Wqid) == 0 {
+				return rerror(err)
+			}
+			return r //the walk failed after the first name, newfid is not affected
+		}
+		n = child
+		r.Wqid = append(r.Wqid, n.qid())
+	}
+	c.fids[t.Newfid] = &fid{n: n}
+	return r
+}
+
+func (c *conn) open(f *fid, t *Fcall) *Fcall {
+	if f.open {
+		return rerror(errors.New("file already open"))
+	}
+	if t.Mode&3 == oWrite || t.Mode&3 == oRdwr || t.Mode&oTrunc != 0 {
+		return rerror(errReadOnly)
+	}
+	if f.n.isDir() {
+		children, err := c.tr.readDir(f.n)
+		if err != nil {
+			return rerror(err)
+		}
+		for _, child := range children {
+			f.dirOff = append(f.dirOff, len(f.dir))
+			f.dir = append(f.dir, packDir(c.tr.stat(child))...)
+		}
+	} else {
+		fd, err := os.Open(f.n.path)
+		if err != nil {
+			return rerror(err)
+		}
+		f.f = fd
+	}
+	f.open = true
+	return &Fcall{Type: Ropen, Qid: f.n.qid(), Iounit: c.msize - ioHdrSz}
+}
+
+func (c *conn) read(f *fid, t *Fcall) *Fcall {
+	if !f.open {
+		return rerror(errors.New("file not open"))
+	}
+	count := t.Count
+	if count > c.msize-ioHdrSz {
+		count = c.msize - ioHdrSz
+	}
+	if f.f != nil {
+		data := make([]byte, count)
+		n, err := f.f.ReadAt(data, int64(t.Offset))
+		if err != nil && err != io.EOF {
+			return rerror(err)
+		}
+		return &Fcall{Type: Rread, Data: data[:n]}
+	}
+	//directories are read in whole stats, from where the last read ended
+	off := int(t.Offset)
+	if t.Offset >= uint64(len(f.dir)) {
+		return &Fcall{Type: Rread}
+	}
+	i := 0
+	for i < len(f.dirOff) && f.dirOff[i] < off {
+		i++
+	}
+	if i == len(f.dirOff) || f.dirOff[i] != off {
+		return rerror(errors.New("bad offset in directory read"))
+	}
+	end := off
+	for ; i < len(f.dirOff); i++ {
+		next := len(f.dir)
+		if i+1 < len(f.dirOff) {
+			next = f.dirOff[i+1]
+		}
+		if next-off > int(count) {
+			break
+		}
+		end = next
+	}
+	if end == off {
+		return rerror(errors.New("directory read too small"))
+	}
+	return &Fcall{Type: Rread, Data: f.dir[off:end]}
+}
+
+func main() {
+	var roots dnav.Roots
+
+	rdFlags()
+	if len(flag.Args()) != 0 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+		os.Remove(addr)
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving %s on %s!%s", roots.DumpRoot, network, addr)
+	tr := &tree{roots: roots, nProcs: nProcs, start: time.Now(), refresh: refresh}
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			defer nc.Close()
+			if err := serve(tr, nc); err != nil {
+				Dprintf("%s: %s\n", nc.RemoteAddr(), err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+//client is a minimal 9P client, sending one message at a time
+type client struct {
+	t     *testing.T
+	c     net.Conn
+	msize uint32
+}
+
+func (cl *client) rpc(f *Fcall) (r *Fcall) {
+	f.Tag = 1
+	if _, err := cl.c.Write(pack(f)); err != nil {
+		cl.t.Fatalf("write %s: %s", f, err)
+	}
+	r, err := readMsg(cl.c, maxMsg)
+	if err != nil {
+		cl.t.Fatalf("read reply to %s: %s", f, err)
+	}
+	if r.Tag != f.Tag {
+		cl.t.Fatalf("bad tag in reply to %s", f)
+	}
+	return r
+}
+
+//mustRPC is rpc, failing if the reply is not of the type expected
+func (cl *client) mustRPC(f *Fcall) *Fcall {
+	r := cl.rpc(f)
+	if r.Type != f.Type+1 {
+		cl.t.Fatalf("bad reply to %s: %s %s", f, r, r.Ename)
+	}
+	return r
+}
+
+func (cl *client) walk(fid uint32, newfid uint32, names ...string) *Fcall {
+	return cl.rpc(&Fcall{Type: Twalk, Fid: fid, Newfid: newfid, Wname: names})
+}
+
+//readAll reads an open file with reads of count bytes
+func (cl *client) readAll(fid uint32, count uint32) (data []byte) {
+	for {
+		r := cl.mustRPC(&Fcall{Type: Tread, Fid: fid, Offset: uint64(len(data)), Count: count})
+		if len(r.Data) == 0 {
+			return data
+		}
+		data = append(data, r.Data...)
+	}
+}
+
+//cat reads a file by its path from the root, in fid 1
+func (cl *client) cat(names ...string) string {
+	cl.mustRPC(&Fcall{Type: Twalk, Fid: 0, Newfid: 1, Wname: names})
+	cl.mustRPC(&Fcall{Type: Topen, Fid: 1, Mode: oRead})
+	data := cl.readAll(1, 5)
+	cl.mustRPC(&Fcall{Type: Tclunk, Fid: 1})
+	return string(data)
+}
+
+//ls lists a directory by its path from the root, in fid 1
+func (cl *client) ls(names ...string) (dirs []Dir) {
+	cl.mustRPC(&Fcall{Type: Twalk, Fid: 0, Newfid: 1, Wname: names})
+	cl.mustRPC(&Fcall{Type: Topen, Fid: 1, Mode: oRead})
+	data := cl.readAll(1, 200)
+	cl.mustRPC(&Fcall{Type: Tclunk, Fid: 1})
+	for len(data) > 0 {
+		n := 2 + int(data[0]) + int(data[1])<<8
+		d, err := unpackDir(data[:n])
+		if err != nil {
+			cl.t.Fatalf("bad directory entry: %s", err)
+		}
+		dirs = append(dirs, d)
+		data = data[n:]
+	}
+	return dirs
+}
+
+func names(dirs []Dir) (ns []string) {
+	for _, d := range dirs {
+		ns = append(ns, d.Name)
+	}
+	return ns
+}
+
+func sameNames(ns []string, ns2 ...string) bool {
+	if len(ns) != len(ns2) {
+		return false
+	}
+	for i := range ns {
+		if ns[i] != ns2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//mkDump takes three snapshots, the file changes in the last two
+func mkDump(t *testing.T) (roots dnav.Roots) {
+	tmp := t.TempDir()
+	live := tmp + "/ROOT"
+	os.MkdirAll(live+"/a", 0755)
+	os.MkdirAll(tmp+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmp+"/dump")
+	dnav.RdRoots(&roots)
+
+	t1 := time.Date(2017, 5, 10, 16, 5, 0, 0, time.Local)
+	for i, txt := range []string{"one\n", "one\n", "two\n"} {
+		os.WriteFile(live+"/a/f.txt", []byte(txt), 0644)
+		if _, _, err := dnav.TakeSnapshot(roots, t1.Add(time.Duration(i)*24*time.Hour), nil); err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+	}
+	return roots
+}
+
+//mkClient connects a client to a server of the dump of mkDump, attached in fid 0
+func mkClient(t *testing.T) *client {
+	c, sc := net.Pipe()
+	tr := &tree{roots: mkDump(t), nProcs: 2, start: time.Now()}
+	go func() {
+		serve(tr, sc)
+		sc.Close()
+	}()
+	t.Cleanup(func() { c.Close() })
+	cl := &client{t: t, c: c}
+	r := cl.mustRPC(&Fcall{Type: Tversion, Msize: 8192, Version: "9P2000"})
+	if r.Msize != 8192 || r.Version != "9P2000" {
+		t.Fatalf("bad version %d %s", r.Msize, r.Version)
+	}
+	cl.mustRPC(&Fcall{Type: Tattach, Fid: 0, Afid: noFid, Uname: "glenda"})
+	return cl
+}
+
+func TestAt(t *testing.T) {
+	cl := mkClient(t)
+	if ns := names(cl.ls()); !sameNames(ns, "at", "versions") {
+		t.Fatalf("bad root %v", ns)
+	}
+	if ns := names(cl.ls("at")); !sameNames(ns, "2017-05-10T16:05", "2017-05-11T16:05", "2017-05-12T16:05") {
+		t.Fatalf("bad at %v", ns)
+	}
+	dirs := cl.ls("at", "2017-05-12T16:05", "a")
+	if len(dirs) != 1 || dirs[0].Name != "f.txt" || dirs[0].Length != 4 || dirs[0].Qid.Type != qtFile {
+		t.Fatalf("bad directory %v", dirs)
+	}
+	//dates resolve to the snapshot not after them, also on previous days
+	for _, d := range []string{"2017-05-12T16:05", "2017-05-12", "2017-05-13T10:00", "2018-01-01"} {
+		if txt := cl.cat("at", d, "a", "f.txt"); txt != "two\n" {
+			t.Fatalf("bad file at %s: %s", d, txt)
+		}
+	}
+	if txt := cl.cat("at", "2017-05-12T10:00", "a", "f.txt"); txt != "one\n" {
+		t.Fatalf("bad file at 2017-05-12T10:00: %s", txt)
+	}
+	if r := cl.walk(0, 1, "at", "2016-01-01"); r.Type != Rwalk || len(r.Wqid) != 1 {
+		t.Fatalf("should walk only at: %s %s", r, r.Ename)
+	}
+	if r := cl.walk(0, 1, "nothere"); r.Type != Rerror {
+		t.Fatalf("should error, no file")
+	}
+	r := cl.mustRPC(&Fcall{Type: Twalk, Fid: 0, Newfid: 1, Wname: []string{"at", "2017-05-10T16:05", "a", "..", "a", "f.txt"}})
+	if len(r.Wqid) != 6 {
+		t.Fatalf("bad walk %v", r.Wqid)
+	}
+	st := cl.mustRPC(&Fcall{Type: Tstat, Fid: 1})
+	d, err := unpackDir(st.Stat)
+	if err != nil || d.Name != "f.txt" || d.Mode != 0444 {
+		t.Fatalf("bad stat %v %s", d, err)
+	}
+	for _, f := range []*Fcall{
+		{Type: Topen, Fid: 1, Mode: oWrite},
+		{Type: Twrite, Fid: 1, Data: []byte("x")},
+		{Type: Twstat, Fid: 1, Stat: st.Stat},
+		{Type: Tremove, Fid: 1},
+	} {
+		if r := cl.rpc(f); r.Type != Rerror {
+			t.Fatalf("should not be able to write with %s", f)
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	cl := mkClient(t)
+	if ns := names(cl.ls("versions")); !sameNames(ns, "a") {
+		t.Fatalf("bad versions %v", ns)
+	}
+	dirs := cl.ls("versions", "a")
+	if len(dirs) != 1 || dirs[0].Name != "f.txt" || dirs[0].Qid.Type != qtDir {
+		t.Fatalf("bad versions of a %v", dirs)
+	}
+	if ns := names(cl.ls("versions", "a", "f.txt")); !sameNames(ns, "2017-05-10T16:05", "2017-05-12T16:05") {
+		t.Fatalf("bad versions of f.txt %v", ns)
+	}
+	if txt := cl.cat("versions", "a", "f.txt", "2017-05-10T16:05"); txt != "one\n" {
+		t.Fatalf("bad first version %s", txt)
+	}
+	if txt := cl.cat("versions", "a", "f.txt", "2017-05-12T16:05"); txt != "two\n" {
+		t.Fatalf("bad last version %s", txt)
+	}
+	if r := cl.walk(0, 1, "versions", "a", "f.txt", "2017-05-11T16:05"); r.Type != Rwalk || len(r.Wqid) != 3 {
+		t.Fatalf("should not find a version which is not distinct")
+	}
+	if r := cl.walk(0, 1, "versions", "nothere"); r.Type == Rwalk && len(r.Wqid) == 2 {
+		t.Fatalf("should not find a path without versions")
+	}
+}
+
+func TestHistoryCache(t *testing.T) {
+	roots := mkDump(t)
+	tr := &tree{roots: roots, nProcs: 2, start: time.Now(), refresh: time.Hour}
+	nVersions := func() int {
+		n := tr.root()
+		for _, name := range []string{"versions", "a", "f.txt"} {
+			var err error
+			if n, err = tr.walk(n, name); err != nil {
+				t.Fatalf("should walk %s: %s", name, err)
+			}
+		}
+		vs, err := tr.readDir(n)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		return len(vs)
+	}
+	if n := nVersions(); n != 2 {
+		t.Fatalf("bad versions %d", n)
+	}
+	os.WriteFile(roots.MainRoot+"/a/f.txt", []byte("three\n"), 0644)
+	if _, _, err := dnav.TakeSnapshot(roots, time.Date(2017, 5, 13, 16, 5, 0, 0, time.Local), nil); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if n := nVersions(); n != 2 {
+		t.Fatalf("should keep the versions until the refresh, got %d", n)
+	}
+	tr.refresh = 0
+	if n := nVersions(); n != 3 {
+		t.Fatalf("should find the new version after the refresh, got %d", n)
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//9P2000 message types, see intro(5) in Plan 9
+const (
+	Tversion = 100 + iota
+	Rversion
+	Tauth
+	Rauth
+	Tattach
+	Rattach
+	Terror //illegal
+	Rerror
+	Tflush
+	Rflush
+	Twalk
+	Rwalk
+	Topen
+	Ropen
+	Tcreate
+	Rcreate
+	Tread
+	Rread
+	Twrite
+	Rwrite
+	Tclunk
+	Rclunk
+	Tremove
+	Rremove
+	Tstat
+	Rstat
+	Twstat
+	Rwstat
+)
+
+const (
+	version = "9P2000"
+	noTag   = 0xffff
+	noFid   = 0xffffffff
+	ioHdrSz = 24 //size of the header of Rread and Twrite
+	maxMsg  = 64*1024 + ioHdrSz
+	maxWalk = 16 //names in a Twalk
+
+	//qid types and mode bits
+	qtDir  = 0x80
+	qtFile = 0x00
+	dmDir  = 0x80000000
+
+	//open modes
+	oRead  = 0
+	oWrite = 1
+	oRdwr  = 2
+	oExec  = 3
+	oTrunc = 0x10
+)
+
+//A Qid identifies a file in the server
+type Qid struct {
+	Type uint8
+	Vers uint32
+	Path uint64
+}
+
+//A Dir is the stat of a file
+type Dir struct {
+	Type   uint16
+	Dev    uint32
+	Qid    Qid
+	Mode   uint32
+	Atime  uint32
+	Mtime  uint32
+	Length uint64
+	Name   string
+	Uid    string
+	Gid    string
+	Muid   string
+}
+
+//An Fcall is a 9P message, only the fields for its type are used
+type Fcall struct {
+	Type    uint8
+	Tag     uint16
+	Fid     uint32
+	Msize   uint32
+	Version string
+	Ename   string
+	Afid    uint32
+	Uname   string
+	Aname   string
+	Oldtag  uint16
+	Qid     Qid
+	Iounit  uint32
+	Newfid  uint32
+	Wname   []string
+	Wqid    []Qid
+	Mode    uint8
+	Name    string
+	Perm    uint32
+	Offset  uint64
+	Count   uint32
+	Data    []byte
+	Stat    []byte
+}
+
+func (f *Fcall) String() string {
+	return fmt.Sprintf("type %d tag %d fid %d", f.Type, f.Tag, f.Fid)
+}
+
+var errShort = errors.New("short 9P message")
+
+//buf packs and unpacks the fields of messages, little endian
+type buf struct {
+	b   []byte
+	err error
+}
+
+func (b *buf) p8(v uint8) {
+	b.b = append(b.b, v)
+}
+
+func (b *buf) p16(v uint16) {
+	b.b = append(b.b, byte(v), byte(v>>8))
+}
+
+func (b *buf) p32(v uint32) {
+	b.b = append(b.b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (b *buf) p64(v uint64) {
+	b.p32(uint32(v))
+	b.p32(uint32(v >> 32))
+}
+
+func (b *buf) pstr(s string) {
+	b.p16(uint16(len(s)))
+	b.b = append(b.b, s...)
+}
+
+func (b *buf) pqid(q Qid) {
+	b.p8(q.Type)
+	b.p32(q.Vers)
+	b.p64(q.Path)
+}
+
+func (b *buf) pdata(d []byte) {
+	b.p32(uint32(len(d)))
+	b.b = append(b.b, d...)
+}
+
+//take returns the next n bytes, or nil if there are not so many
+func (b *buf) take(n int) []byte {
+	if b.err != nil || len(b.b) < n {
+		b.err = errShort
+		return nil
+	}
+	v := b.b[:n]
+	b.b = b.b[n:]
+	return v
+}
+
+func (b *buf) g8() uint8 {
+	if v := b.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (b *buf) g16() uint16 {
+	if v := b.take(2); v != nil {
+		return binary.LittleEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (b *buf) g32() uint32 {
+	if v := b.take(4); v != nil {
+		return binary.LittleEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (b *buf) g64() uint64 {
+	if v := b.take(8); v != nil {
+		return binary.LittleEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (b *buf) gstr() string {
+	return string(b.take(int(b.g16())))
+}
+
+func (b *buf) gqid() (q Qid) {
+	q.Type = b.g8()
+	q.Vers = b.g32()
+	q.Path = b.g64()
+	return q
+}
+
+func (b *buf) gdata() []byte {
+	return b.take(int(b.g32()))
+}
+
+//packDir packs a stat, as in the Rstat messages and the data of directories
+func packDir(d *Dir) []byte {
+	var b buf
+	b.p16(0) //size, below
+	b.p16(d.Type)
+	b.p32(d.Dev)
+	b.pqid(d.Qid)
+	b.p32(d.Mode)
+	b.p32(d.Atime)
+	b.p32(d.Mtime)
+	b.p64(d.Length)
+	b.pstr(d.Name)
+	b.pstr(d.Uid)
+	b.pstr(d.Gid)
+	b.pstr(d.Muid)
+	binary.LittleEndian.PutUint16(b.b, uint16(len(b.b)-2))
+	return b.b
+}
+
+func unpackDir(data []byte) (d Dir, err error) {
+	b := buf{b: data}
+	if n := int(b.g16()); n != len(data)-2 {
+		return d, errShort
+	}
+	d.Type = b.g16()
+	d.Dev = b.g32()
+	d.Qid = b.gqid()
+	d.Mode = b.g32()
+	d.Atime = b.g32()
+	d.Mtime = b.g32()
+	d.Length = b.g64()
+	d.Name = b.gstr()
+	d.Uid = b.gstr()
+	d.Gid = b.gstr()
+	d.Muid = b.gstr()
+	return d, b.err
+}
+
+//pack encodes a message, with its size
+func pack(f *Fcall) []byte {
+	var b buf
+	b.p32(0) //size, below
+	b.p8(f.Type)
+	b.p16(f.Tag)
+	switch f.Type {
+	case Tversion, Rversion:
+		b.p32(f.Msize)
+		b.pstr(f.Version)
+	case Tauth:
+		b.p32(f.Afid)
+		b.pstr(f.Uname)
+		b.pstr(f.Aname)
+	case Rauth, Rattach:
+		b.pqid(f.Qid)
+	case Tattach:
+		b.p32(f.Fid)
+		b.p32(f.Afid)
+		b.pstr(f.Uname)
+		b.pstr(f.Aname)
+	case Rerror:
+		b.pstr(f.Ename)
+	case Tflush:
+		b.p16(f.Oldtag)
+	case Twalk:
+		b.p32(f.Fid)
+		b.p32(f.Newfid)
+		b.p16(uint16(len(f.Wname)))
+		for _, n := range f.Wname {
+			b.pstr(n)
+		}
+	case Rwalk:
+		b.p16(uint16(len(f.Wqid)))
+		for _, q := range f.Wqid {
+			b.pqid(q)
+		}
+	case Topen:
+		b.p32(f.Fid)
+		b.p8(f.Mode)
+	case Ropen, Rcreate:
+		b.pqid(f.Qid)
+		b.p32(f.Iounit)
+	case Tcreate:
+		b.p32(f.Fid)
+		b.pstr(f.Name)
+		b.p32(f.Perm)
+		b.p8(f.Mode)
+	case Tread:
+		b.p32(f.Fid)
+		b.p64(f.Offset)
+		b.p32(f.Count)
+	case Rread:
+		b.pdata(f.Data)
+	case Twrite:
+		b.p32(f.Fid)
+		b.p64(f.Offset)
+		b.pdata(f.Data)
+	case Rwrite:
+		b.p32(f.Count)
+	case Tclunk, Tremove, Tstat:
+		b.p32(f.Fid)
+	case Rstat:
+		b.p16(uint16(len(f.Stat)))
+		b.b = append(b.b, f.Stat...)
+	case Twstat:
+		b.p32(f.Fid)
+		b.p16(uint16(len(f.Stat)))
+		b.b = append(b.b, f.Stat...)
+	}
+	binary.LittleEndian.PutUint32(b.b, uint32(len(b.b)))
+	return b.b
+}
+
+//unpack decodes a message without its size
+func unpack(data []byte) (f *Fcall, err error) {
+	b := buf{b: data}
+	f = &Fcall{}
+	f.Type = b.g8()
+	f.Tag = b.g16()
+	switch f.Type {
+	case Tversion, Rversion:
+		f.Msize = b.g32()
+		f.Version = b.gstr()
+	case Tauth:
+		f.Afid = b.g32()
+		f.Uname = b.gstr()
+		f.Aname = b.gstr()
+	case Rauth, Rattach:
+		f.Qid = b.gqid()
+	case Tattach:
+		f.Fid = b.g32()
+		f.Afid = b.g32()
+		f.Uname = b.gstr()
+		f.Aname = b.gstr()
+	case Rerror:
+		f.Ename = b.gstr()
+	case Tflush:
+		f.Oldtag = b.g16()
+	case Twalk:
+		f.Fid = b.g32()
+		f.Newfid = b.g32()
+		n := int(b.g16())
+		if n > maxWalk {
+			return nil, errors.New("too many names in walk")
+		}
+		for i := 0; i < n; i++ {
+			f.Wname = append(f.Wname, b.gstr())
+		}
+	case Rwalk:
+		n := int(b.g16())
+		if n > maxWalk {
+			return nil, errors.New("too many qids in walk")
+		}
+		for i := 0; i < n; i++ {
+			f.Wqid = append(f.Wqid, b.gqid())
+		}
+	case Topen:
+		f.Fid = b.g32()
+		f.Mode = b.g8()
+	case Ropen, Rcreate:
+		f.Qid = b.gqid()
+		f.Iounit = b.g32()
+	case Tcreate:
+		f.Fid = b.g32()
+		f.Name = b.gstr()
+		f.Perm = b.g32()
+		f.Mode = b.g8()
+	case Tread:
+		f.Fid = b.g32()
+		f.Offset = b.g64()
+		f.Count = b.g32()
+	case Rread:
+		f.Data = b.gdata()
+	case Twrite:
+		f.Fid = b.g32()
+		f.Offset = b.g64()
+		f.Data = b.gdata()
+	case Rwrite:
+		f.Count = b.g32()
+	case Tclunk, Tremove, Tstat:
+		f.Fid = b.g32()
+	case Rflush, Rclunk, Rremove, Rwstat:
+	case Rstat:
+		f.Stat = b.take(int(b.g16()))
+	case Twstat:
+		f.Fid = b.g32()
+		f.Stat = b.take(int(b.g16()))
+	default:
+		return nil, fmt.Errorf("bad 9P message type %d", f.Type)
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.b) != 0 {
+		return nil, errors.New("9P message too long")
+	}
+	return f, nil
+}
+
+//readMsg reads a message, which cannot be bigger than msize
+func readMsg(r io.Reader, msize uint32) (f *Fcall, err error) {
+	var sz [4]byte
+	if _, err := io.ReadFull(r, sz[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(sz[:])
+	if n < 7 || n > msize {
+		return nil, fmt.Errorf("bad 9P message size %d", n)
+	}
+	data := make([]byte, n-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return unpack(data)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+//dateLayout names the snapshots in /at and the versions in /versions
+const dateLayout = "2006-01-02T15:04"
+
+var (
+	errNotFound = errors.New("file does not exist")
+	errNotDir   = errors.New("not a directory")
+)
+
+//kinds of nodes of the tree served
+const (
+	kRoot    = iota //the root, with at and versions
+	kAt             //at, with a directory for each snapshot
+	kReal           //a file or directory of a snapshot
+	kVpath          //versions or a directory in it, for a path relative to the main root
+	kVersion        //a version of a file, named after its snapshot
+)
+
+//A node is a file of the tree served
+type node struct {
+	kind   int
+	name   string
+	parent *node
+	snap   dnav.Snapshot //for kReal and kVersion
+	rel    string        //for kReal, kVpath and kVersion
+	path   string        //in the dump, for kReal and kVersion
+	fi     os.FileInfo   //for kReal and kVersion, and kVpath if known
+
+	versions []dnav.Version //for kVpath, see history
+	hasHist  bool
+}
+
+//tree is the tree of files served for a dump
+type tree struct {
+	roots   dnav.Roots
+	nProcs  int
+	start   time.Time     //times of the synthetic directories
+	refresh time.Duration //how often the list of snapshots is read again
+
+	mu     sync.Mutex
+	snaps  []dnav.Snapshot //the list of snapshots, listed at listed
+	listed time.Time
+	hists  map[string][]dnav.Version //the versions of the paths, for snaps
+}
+
+func (tr *tree) root() *node {
+	return &node{kind: kRoot, name: "/"}
+}
+
+func (n *node) isDir() bool {
+	switch n.kind {
+	case kReal, kVersion:
+		return n.fi.IsDir()
+	}
+	return true
+}
+
+func (n *node) qid() Qid {
+	h := fnv.New64a()
+	h.Write([]byte{byte(n.kind)})
+	switch n.kind {
+	case kReal, kVersion:
+		h.Write([]byte(n.path))
+	case kVpath:
+		h.Write([]byte(n.rel))
+	}
+	q := Qid{Path: h.Sum64()}
+	if n.isDir() {
+		q.Type = qtDir
+	}
+	return q
+}
+
+func (tr *tree) stat(n *node) *Dir {
+	d := &Dir{
+		Qid:   n.qid(),
+		Name:  n.name,
+		Uid:   "dump",
+		Gid:   "dump",
+		Muid:  "dump",
+		Mode:  dmDir | 0555,
+		Mtime: uint32(tr.start.Unix()),
+	}
+	if n.fi != nil {
+		d.Mtime = uint32(n.fi.ModTime().Unix())
+	}
+	if n.kind == kReal || n.kind == kVersion {
+		if n.fi.IsDir() {
+			d.Mode = dmDir | uint32(n.fi.Mode().Perm()&0555)
+		} else {
+			d.Mode = uint32(n.fi.Mode().Perm() & 0555)
+			d.Length = uint64(n.fi.Size())
+		}
+	}
+	d.Atime = d.Mtime
+	return d
+}
+
+//snapshot finds the snapshot for a name in /at, a date as in dnav.ParseDate
+func (tr *tree) snapshot(name string) (s dnav.Snapshot, err error) {
+	d, err := dnav.ParseDateEnd(name, tr.roots)
+	if err != nil {
+		return s, errNotFound
+	}
+	p := dnav.FindDumpPath(d, tr.roots)
+	s.Date, err = dnav.ParseDumpPath(p, tr.roots)
+	if err == nil && p == tr.roots.DumpRoot+"/"+s.Date.Name() {
+		s.Path = p
+		return s, nil
+	}
+	//FindDumpPath does not look in the previous days if the day has no snapshot
+	return dnav.FindSnapshot(d, tr.roots)
+}
+
+//snapshots returns the list of snapshots, walking the dump again only when
+//the list is older than the refresh period. The versions found are forgotten then.
+func (tr *tree) snapshots() ([]dnav.Snapshot, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.snaps != nil && time.Since(tr.listed) < tr.refresh {
+		return tr.snaps, nil
+	}
+	snaps, err := dnav.ListSnapshots(tr.roots, dnav.DumpDate{}, tr.nProcs)
+	if err != nil {
+		return nil, err
+	}
+	tr.snaps, tr.listed = snaps, time.Now()
+	tr.hists = make(map[string][]dnav.Version)
+	return snaps, nil
+}
+
+//history finds (once) the versions of the path of a kVpath node, they
+//are kept in the tree for the other nodes of the same path
+func (tr *tree) history(n *node) error {
+	if n.hasHist {
+		return nil
+	}
+	snaps, err := tr.snapshots()
+	if err != nil {
+		return err
+	}
+	tr.mu.Lock()
+	versions, ok := tr.hists[n.rel]
+	listed := tr.listed
+	tr.mu.Unlock()
+	if !ok {
+		if versions, err = dnav.History(snaps, tr.roots, n.rel, tr.nProcs); err != nil {
+			return err
+		}
+		tr.mu.Lock()
+		if tr.listed.Equal(listed) {
+			tr.hists[n.rel] = versions
+		}
+		tr.mu.Unlock()
+	}
+	n.versions, n.hasHist = versions, true
+	return nil
+}
+
+//last is the last version of a kVpath node, nil if it does not exist now
+func (n *node) last() *dnav.Version {
+	for i := len(n.versions) - 1; i >= 0; i-- {
+		if n.versions[i].Info != nil {
+			return &n.versions[i]
+		}
+	}
+	return nil
+}
+
+//vpathIsDir finds if the path of a kVpath node is a directory in its last version,
+//then the node has the names in it, else it has the versions of the file
+func (tr *tree) vpathIsDir(n *node) (bool, error) {
+	if n.rel == "" {
+		return true, nil
+	}
+	if err := tr.history(n); err != nil {
+		return false, err
+	}
+	last := n.last()
+	if last == nil {
+		return false, errNotFound
+	}
+	return last.Info.IsDir(), nil
+}
+
+//isDistinct is true for the versions with new content
+func isDistinct(v *dnav.Version) bool {
+	return v.Kind == dnav.Create || v.Kind == dnav.Write
+}
+
+func versionName(s *dnav.Snapshot) string {
+	return s.Date.Time().Format(dateLayout)
+}
+
+//walk finds the child of a directory
+func (tr *tree) walk(n *node, name string) (c *node, err error) {
+	if name == ".." {
+		if n.parent == nil {
+			return n, nil
+		}
+		return n.parent, nil
+	}
+	if !n.isDir() {
+		return nil, errNotDir
+	}
+	if name == "." || name == "" || strings.Contains(name, "/") {
+		return nil, errNotFound
+	}
+	c = &node{name: name, parent: n}
+	switch n.kind {
+	case kRoot:
+		switch name {
+		case "at":
+			c.kind = kAt
+		case "versions":
+			c.kind = kVpath
+		default:
+			return nil, errNotFound
+		}
+	case kAt:
+		s, err := tr.snapshot(name)
+		if err != nil {
+			return nil, errNotFound
+		}
+		c.kind, c.snap, c.rel = kReal, s, ""
+	case kReal:
+		c.kind, c.snap, c.rel = kReal, n.snap, n.rel+"/"+name
+	case kVpath:
+		isDir, err := tr.vpathIsDir(n)
+		if err != nil {
+			return nil, err
+		}
+		if isDir {
+			c.kind, c.rel = kVpath, n.rel+"/"+name
+			if _, err := tr.vpathIsDir(c); err != nil {
+				return nil, err
+			}
+			c.fi = c.last().Info
+			return c, nil
+		}
+		for _, v := range n.versions {
+			if isDistinct(&v) && versionName(&v.Snapshot) == name {
+				c.kind, c.snap, c.rel = kVersion, v.Snapshot, n.rel
+				break
+			}
+		}
+		if c.kind != kVersion {
+			return nil, errNotFound
+		}
+	default:
+		return nil, errNotFound
+	}
+	if c.kind == kReal || c.kind == kVersion {
+		if c.path, c.fi, err = c.snap.Resolve(c.rel, tr.roots); err != nil {
+			return nil, errNotFound
+		}
+	}
+	return c, nil
+}
+
+//readDir lists the children of a directory
+func (tr *tree) readDir(n *node) (children []*node, err error) {
+	child := func(name string) {
+		c, err := tr.walk(n, name)
+		if err != nil {
+			Dprintf("readdir %s: %s\n", name, err)
+			return
+		}
+		children = append(children, c)
+	}
+	switch n.kind {
+	case kRoot:
+		child("at")
+		child("versions")
+	case kAt:
+		snaps, err := tr.snapshots()
+		if err != nil {
+			return nil, err
+		}
+		for i := range snaps {
+			c := &node{kind: kReal, name: versionName(&snaps[i]), parent: n, snap: snaps[i]}
+			if c.path, c.fi, err = c.snap.Resolve("", tr.roots); err == nil {
+				children = append(children, c)
+			}
+		}
+	case kReal:
+		files, err := ioutil.ReadDir(n.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			child(f.Name())
+		}
+	case kVpath:
+		isDir, err := tr.vpathIsDir(n)
+		if err != nil {
+			return nil, err
+		}
+		if !isDir {
+			for _, v := range n.versions {
+				if isDistinct(&v) {
+					child(versionName(&v.Snapshot))
+				}
+			}
+			return children, nil
+		}
+		var p string
+		if n.rel == "" {
+			snaps, err := tr.snapshots()
+			if err != nil || len(snaps) == 0 {
+				return nil, err
+			}
+			p = snaps[len(snaps)-1].PathOf("", tr.roots)
+		} else {
+			last := n.last()
+			if p, _, err = last.Snapshot.Resolve(n.rel, tr.roots); err != nil {
+				return nil, err
+			}
+		}
+		files, err := ioutil.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		//their versions are found when they are walked to
+		for _, f := range files {
+			children = append(children, &node{kind: kVpath, name: f.Name(), parent: n, rel: n.rel + "/" + f.Name(), fi: f})
+		}
+	default:
+		return nil, errNotDir
+	}
+	return children, nil
+}
+
+//String is the path of a node in the tree, for debugging
+func (n *node) String() string {
+	if n.parent == nil {
+		return "/"
+	}
+	return path.Join(n.parent.String(), n.name)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug    bool
+	allFlag  bool
+	nProcs   int
+	fromStr  string
+	untilStr string
+	prunes   []string
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	a := flag.Bool("a", false, "all the files, also inside missing directories")
+	p := flag.Int("p", runtime.NumCPU(), "# of snapshots read concurrently")
+	s := flag.String("s", "", "earliest date or dump path")
+	e := flag.String("e", "", "latest date or dump path")
+	x := flag.String("x", "", "comma separated names of directories not to descend into")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	allFlag = *a
+	nProcs = *p
+	fromStr = *s
+	untilStr = *e
+	if *x != "" {
+		prunes = strings.Split(*x, ",")
+	}
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dgone: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dgone [-Da] [-p=nprocs] [-s=earliest] [-e=latest] [-x=dir,dir...] dir")
+}
+
+//lastSeen finds, for each path under rel in any of the snapshots,
+//the index of the last snapshot which has it
+func lastSeen(snaps []dnav.Snapshot, roots dnav.Roots, rel string) map[string]int {
+	last := make(map[string]int)
+	var lk sync.Mutex
+	err := dnav.WalkSnapshots(snaps, roots, rel, dnav.Prune(prunes), nProcs, func(i int, r string, fi os.FileInfo) error {
+		lk.Lock()
+		defer lk.Unlock()
+		if l, ok := last[r]; !ok || i > l {
+			last[r] = i
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dgone: %s\n", err)
+	}
+	return last
+}
+
+//inGone reports if the path rel is inside one of the directories gone
+func inGone(rel string, gone map[string]bool) bool {
+	for d := filepath.Dir(rel); d != "/" && d != "."; d = filepath.Dir(d) {
+		if gone[d] {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	var (
+		roots       dnav.Roots
+		from, until dnav.DumpDate
+		err         error
+	)
+
+	rdFlags()
+	args := flag.Args()
+	if len(args) != 1 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	rel, err := dnav.RelPath(path, roots)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dnav.IsDump(path, roots) {
+		log.Fatal("not a path in the main root: " + path)
+	}
+	if fromStr != "" {
+		if from, err = dnav.ParseDate(fromStr, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if untilStr != "" {
+		if until, err = dnav.ParseDateEnd(untilStr, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	Dprintf("from %s until %s rel %s\n", &from, &until, rel)
+
+	snaps, err := dnav.ListSnapshots(roots, from, nProcs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	snaps = dnav.Until(snaps, until)
+	if len(snaps) == 0 {
+		log.Fatal("no dumps")
+	}
+
+	last := lastSeen(snaps, roots, rel)
+	paths := make([]string, 0, len(last))
+	for p := range last {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	gone := make(map[string]bool)
+	for _, p := range paths {
+		if !allFlag && inGone(p, gone) {
+			continue
+		}
+		if _, err := os.Lstat(dnav.LivePath(p, roots)); !os.IsNotExist(err) {
+			continue
+		}
+		gone[p] = true
+		s := &snaps[last[p]]
+		fmt.Printf("%s\t%s\t%s\n", dnav.LivePath(p, roots), s.Date.Name(), s.PathOf(p, roots))
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug    bool
+	caseFlag bool
+	binFlag  bool
+	fromStr  string
+	untilStr string
+	prunes   []string
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	i := flag.Bool("i", false, "ignore case")
+	a := flag.Bool("a", false, "search binary files too")
+	s := flag.String("s", "", "earliest date or dump path")
+	e := flag.String("e", "", "latest date or dump path")
+	x := flag.String("x", "", "comma separated names of directories not to descend into")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	caseFlag = *i
+	binFlag = *a
+	fromStr = *s
+	untilStr = *e
+	if *x != "" {
+		prunes = strings.Split(*x, ",")
+	}
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dgrep: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dgrep [-Dia] [-s=earliest] [-e=latest] [-x=dir,dir...] regexp path")
+}
+
+const maxLine = 1024 * 1024
+
+//grep searches a file, returning the matching lines. A line longer than maxLine
+//stops the search, which is reported, the lines before it are still matched.
+func grep(path string, re *regexp.Regexp) (matches []string, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	n := 1
+	for ; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if !binFlag && bytes.IndexByte(line, 0) >= 0 {
+			Dprintf("binary file %s\n", path)
+			return nil, nil
+		}
+		if re.Match(line) {
+			matches = append(matches, fmt.Sprintf("%d:%s", n, line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "dgrep: %s: line %d: %s, the rest is not searched\n", path, n, err)
+	}
+	return matches, nil
+}
+
+//version is what a path was the last time it was seen
+type version struct {
+	fi  os.FileInfo
+	sum [32]byte
+}
+
+//dgrep searches the files under rel in the snapshots, in order. The matches
+//of a file are printed when it appears and each time its content changes,
+//also to one it had before or that another file has. Files with the same size
+//and mtime as the previous one with the same path are not even read. Each
+//distinct content is searched once, the files are hashed (or their sums taken
+//from the manifest) and the matches of a content already searched are reused.
+func dgrep(snaps []dnav.Snapshot, roots dnav.Roots, rel string, re *regexp.Regexp) (nMatches int) {
+	last := make(map[string]version)
+	searched := make(map[[32]byte][]string)
+	for i := range snaps {
+		s := &snaps[i]
+		Dprintf("searching %s\n", s.Path)
+		err := dnav.WalkSnapshot(s, roots, rel, dnav.Prune(prunes), func(r string, fi os.FileInfo) error {
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			if v, ok := last[r]; ok && dnav.SameInfo(v.fi, fi) {
+				last[r] = version{fi, v.sum}
+				return nil
+			}
+			p := s.PathOf(r, roots)
+			sum, ok := dnav.ManifestSum(p, fi, roots)
+			if !ok {
+				var err error
+				if sum, err = dnav.HashFile(p); err != nil {
+					fmt.Fprintf(os.Stderr, "dgrep: %s: %s\n", p, err)
+					return nil
+				}
+			}
+			v, ok := last[r]
+			last[r] = version{fi, sum}
+			if ok && v.sum == sum {
+				return nil
+			}
+			matches, ok := searched[sum]
+			if !ok {
+				var err error
+				if matches, err = grep(p, re); err != nil {
+					fmt.Fprintf(os.Stderr, "dgrep: %s: %s\n", p, err)
+					return nil
+				}
+				searched[sum] = matches
+			}
+			for _, m := range matches {
+				fmt.Printf("%s:%s:%s\n", s.Date.Name(), dnav.LivePath(r, roots), m)
+			}
+			nMatches += len(matches)
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dgrep: %s: %s\n", s.Path, err)
+		}
+	}
+	return nMatches
+}
+
+func main() {
+	var (
+		roots       dnav.Roots
+		from, until dnav.DumpDate
+		err         error
+	)
+
+	rdFlags()
+	args := flag.Args()
+	if len(args) != 2 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	expr := args[0]
+	if caseFlag {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	path, err := filepath.Abs(args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	rel, err := dnav.RelPath(path, roots)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if fromStr != "" {
+		if from, err = dnav.ParseDate(fromStr, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if untilStr != "" {
+		if until, err = dnav.ParseDateEnd(untilStr, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	Dprintf("from %s until %s rel %s\n", &from, &until, rel)
+
+	snaps, err := dnav.ListSnapshots(roots, from, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	snaps = dnav.Until(snaps, until)
+	if len(snaps) == 0 {
+		log.Fatal("no dumps")
+	}
+	if dgrep(snaps, roots, rel, re) == 0 {
+		os.Exit(1)
+	}
+}
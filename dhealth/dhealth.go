@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug    bool
+	warnAge  time.Duration
+	critAge  time.Duration
+	cadence  time.Duration
+	window   time.Duration
+	promFile string
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	w := flag.String("w", "26h", "age of the newest dump for a warning")
+	c := flag.String("c", "50h", "age of the newest dump for a critical")
+	g := flag.String("g", "25h", "expected time between dumps, bigger gaps are warnings")
+	r := flag.String("r", "7d", "only the gaps this recent are warnings, the older ones are only counted")
+	p := flag.String("P", "", "prometheus textfile to write")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	var err error
+	if warnAge, err = dnav.ParseAge(*w); err != nil {
+		log.Fatal(err)
+	}
+	if critAge, err = dnav.ParseAge(*c); err != nil {
+		log.Fatal(err)
+	}
+	if cadence, err = dnav.ParseAge(*g); err != nil {
+		log.Fatal(err)
+	}
+	if window, err = dnav.ParseAge(*r); err != nil {
+		log.Fatal(err)
+	}
+	promFile = *p
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dhealth: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dhealth [-D] [-w=age] [-c=age] [-g=age] [-r=age] [-P=promfile]")
+}
+
+//nagios exit status
+const (
+	statusOK = iota
+	statusWarning
+	statusCritical
+	statusUnknown
+)
+
+var statusNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+type problem struct {
+	status int
+	msg    string
+}
+
+//health is what is found about the dump
+type health struct {
+	status     int
+	problems   []problem
+	nSnapshots int
+	age        time.Duration
+	nGaps      int
+	nBad       int
+}
+
+func (h *health) problem(status int, format string, a ...interface{}) {
+	if status > h.status {
+		h.status = status
+	}
+	h.problems = append(h.problems, problem{status, fmt.Sprintf(format, a...)})
+}
+
+//isTruncated finds if a snapshot is missing the root name directory or it is empty
+func isTruncated(s *dnav.Snapshot, roots dnav.Roots) bool {
+	files, err := ioutil.ReadDir(s.PathOf("", roots))
+	return err != nil || len(files) == 0
+}
+
+//check looks at the snapshots and markers of the dump
+func check(roots dnav.Roots, now time.Time) (h *health) {
+	h = &health{}
+	snaps, err := dnav.ListSnapshots(roots, dnav.DumpDate{}, 1)
+	if err != nil {
+		h.problem(statusUnknown, "%s", err)
+		return h
+	}
+	h.nSnapshots = len(snaps)
+	if len(snaps) == 0 {
+		h.problem(statusCritical, "no dumps in %s", roots.DumpRoot)
+		return h
+	}
+	newest := &snaps[len(snaps)-1]
+	h.age = now.Sub(newest.Date.Time())
+	switch {
+	case h.age >= critAge:
+		h.problem(statusCritical, "newest dump %s is %s old", newest.Date.Name(), dnav.FmtAge(h.age))
+	case h.age >= warnAge:
+		h.problem(statusWarning, "newest dump %s is %s old", newest.Date.Name(), dnav.FmtAge(h.age))
+	}
+
+	for i := range snaps {
+		s := &snaps[i]
+		if i > 0 {
+			prev := &snaps[i-1]
+			if gap := s.Date.Time().Sub(prev.Date.Time()); gap > cadence {
+				h.nGaps++
+				if now.Sub(s.Date.Time()) <= window {
+					h.problem(statusWarning, "gap of %s between %s and %s", dnav.FmtAge(gap), prev.Date.Name(), s.Date.Name())
+				} else {
+					Dprintf("old gap of %s between %s and %s\n", dnav.FmtAge(gap), prev.Date.Name(), s.Date.Name())
+				}
+			}
+		}
+		if isTruncated(s, roots) {
+			h.nBad++
+			status := statusWarning
+			if s == newest {
+				status = statusCritical
+			}
+			h.problem(status, "dump %s is empty or has no %s", s.Date.Name(), roots.RootName)
+		}
+	}
+
+	markers := make(map[string]string)
+	for _, m := range []string{dnav.CurrentMarker, dnav.CurrentChkMarker, dnav.FirstMarker} {
+		p, err := dnav.ReadMarker(m, roots)
+		if err != nil {
+			h.problem(statusWarning, "%s", err)
+			continue
+		}
+		markers[m] = p
+		Dprintf("marker %s: %s\n", m, p)
+	}
+	if p := markers[dnav.CurrentChkMarker]; p != "" {
+		if _, err := os.Stat(p); err == nil {
+			h.nBad++
+			h.problem(statusWarning, "dump %s may be incomplete, %s points to it", p, dnav.CurrentChkMarker)
+		} else {
+			h.problem(statusWarning, "dump in progress or aborted, %s points to %s", dnav.CurrentChkMarker, p)
+		}
+	}
+	if p := markers[dnav.CurrentMarker]; p != "" && p != newest.Path {
+		h.problem(statusWarning, "%s points to %s, not to the newest dump %s", dnav.CurrentMarker, p, newest.Date.Name())
+	}
+	if p := markers[dnav.FirstMarker]; p != "" {
+		if _, err := os.Stat(p); err != nil {
+			h.problem(statusWarning, "%s points to %s: %s", dnav.FirstMarker, p, err)
+		}
+	}
+	return h
+}
+
+//writeProm writes the metrics in the format of the prometheus node exporter
+//textfile collector, replacing the file atomically
+func writeProm(file string, h *health, roots dnav.Roots) error {
+	var b bytes.Buffer
+	metric := func(name string, help string, v interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		fmt.Fprintf(&b, "%s{dump=%q} %v\n", name, roots.DumpRoot, v)
+	}
+	metric("dump_status", "Nagios status of the dump, 0 ok, 1 warning, 2 critical, 3 unknown.", h.status)
+	metric("dump_snapshots", "Number of snapshots in the dump.", h.nSnapshots)
+	metric("dump_newest_snapshot_age_seconds", "Age of the newest snapshot.", int64(h.age.Seconds()))
+	metric("dump_gaps", "Number of gaps between snapshots bigger than expected.", h.nGaps)
+	metric("dump_bad_snapshots", "Number of empty, truncated or incomplete snapshots.", h.nBad)
+
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+func main() {
+	var roots dnav.Roots
+
+	rdFlags()
+	if len(flag.Args()) != 0 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	h := check(roots, time.Now())
+	summary := "dump is fine"
+	for _, p := range h.problems {
+		if p.status == h.status {
+			summary = p.msg
+			break
+		}
+	}
+	if len(h.problems) > 1 {
+		summary += fmt.Sprintf(" (and %d more problems)", len(h.problems)-1)
+	}
+	fmt.Printf("DUMP %s - %s | age=%ds;%d;%d snapshots=%d gaps=%d bad=%d\n", statusNames[h.status], summary,
+		int64(h.age.Seconds()), int64(warnAge.Seconds()), int64(critAge.Seconds()), h.nSnapshots, h.nGaps, h.nBad)
+	for _, p := range h.problems {
+		fmt.Printf("%s: %s\n", statusNames[p.status], p.msg)
+	}
+	if promFile != "" {
+		if err := writeProm(promFile, h, roots); err != nil {
+			fmt.Printf("cannot write %s: %s\n", promFile, err)
+			if h.status < statusUnknown {
+				h.status = statusUnknown
+			}
+		}
+	}
+	os.Exit(h.status)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestCheckCorrupted(t *testing.T) {
+	var roots dnav.Roots
+	tmp := t.TempDir()
+	live := tmp + "/ROOT"
+	os.MkdirAll(live, 0755)
+	os.MkdirAll(tmp+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmp+"/dump")
+	dnav.RdRoots(&roots)
+	warnAge, critAge, cadence, window = 26*time.Hour, 50*time.Hour, 25*time.Hour, 7*24*time.Hour
+
+	t1 := time.Date(2017, 5, 10, 16, 5, 0, 0, time.Local)
+	var taken []dnav.Snapshot
+	for _, d := range []int{0, 1, 3, 4} {
+		os.WriteFile(live+"/f.txt", []byte{byte('0' + d)}, 0644)
+		s, _, err := dnav.TakeSnapshot(roots, t1.Add(time.Duration(d)*24*time.Hour), nil)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		taken = append(taken, s)
+	}
+	now := taken[3].Date.Time().Add(time.Hour)
+	h := check(roots, now)
+	if h.status != statusWarning || h.nSnapshots != 4 || h.nGaps != 1 || h.nBad != 0 {
+		t.Fatalf("should only warn about the gap: %d %v", h.status, h.problems)
+	}
+
+	//truncated snapshot in the middle, and a dump aborted over the newest
+	if err := os.RemoveAll(taken[1].PathOf("", roots)); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if err := dnav.WriteMarker(dnav.CurrentChkMarker, &taken[3], roots); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	h = check(roots, now)
+	if h.status != statusWarning || h.nBad != 2 {
+		t.Fatalf("should find the bad snapshots: %d %d %v", h.status, h.nBad, h.problems)
+	}
+	found := false
+	for _, p := range h.problems {
+		found = found || strings.Contains(p.msg, taken[1].Date.Name())
+	}
+	if !found {
+		t.Errorf("should report the truncated snapshot %s: %v", taken[1].Date.Name(), h.problems)
+	}
+
+	//an empty newest snapshot is critical, as is an old dump
+	os.RemoveAll(taken[3].PathOf("", roots))
+	if h = check(roots, now); h.status != statusCritical {
+		t.Errorf("should be critical with the newest snapshot empty: %v", h.problems)
+	}
+	os.Remove(roots.DumpRoot + "/" + dnav.CurrentChkMarker)
+	if h = check(roots, now.Add(3*24*time.Hour)); h.status != statusCritical || h.age < critAge {
+		t.Errorf("should be critical with an old dump: %v", h.problems)
+	}
+}
@@ -0,0 +1,82 @@
+package dnav
+
+import (
+	"errors"
+)
+
+//Results of the tests of Bisect
+const (
+	Good = iota
+	Bad
+	Skip //cannot be tested
+)
+
+//Bisect finds the first bad of n candidates in order, calling test for as few of them as
+//possible. The first candidate has to be good and the last one bad, the changes
+//are supposed to go only from good to bad. It returns the first bad candidate
+//and the last good one before it; if the ones between them are skipped, good+1 < bad.
+func Bisect(n int, test func(i int) (int, error)) (good int, bad int, err error) {
+	if n < 2 {
+		return 0, 0, errors.New("bisect needs a good and a bad candidate")
+	}
+	tested := make(map[int]int)
+	try := func(i int) (int, error) {
+		if r, ok := tested[i]; ok {
+			return r, nil
+		}
+		r, err := test(i)
+		if err == nil {
+			tested[i] = r
+		}
+		return r, err
+	}
+	if r, err := try(n - 1); err != nil || r != Bad {
+		return 0, 0, firstErr(err, errors.New("the last candidate is not bad"))
+	}
+	if r, err := try(0); err != nil || r != Good {
+		return 0, 0, firstErr(err, errors.New("the first candidate is not good"))
+	}
+	good, bad = 0, n-1
+	for bad-good > 1 {
+		r, i := Skip, 0
+		for _, i = range around(good, bad) {
+			if r, err = try(i); err != nil {
+				return good, bad, err
+			}
+			if r != Skip {
+				break
+			}
+		}
+		switch r {
+		case Good:
+			good = i
+		case Bad:
+			bad = i
+		default:
+			return good, bad, nil //all skipped
+		}
+	}
+	return good, bad, nil
+}
+
+//around returns the candidates between good and bad, the one in the
+//middle first and then the ones closer to it
+func around(good int, bad int) (is []int) {
+	mid := (good + bad) / 2
+	for d := 0; mid+d < bad || mid-d > good; d++ {
+		if mid+d < bad {
+			is = append(is, mid+d)
+		}
+		if d > 0 && mid-d > good {
+			is = append(is, mid-d)
+		}
+	}
+	return is
+}
+
+func firstErr(err error, err2 error) error {
+	if err != nil {
+		return err
+	}
+	return err2
+}
@@ -0,0 +1,46 @@
+package dnav_test
+
+import (
+	"testing"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestBisect(t *testing.T) {
+	for n := 2; n < 40; n++ {
+		for first := 1; first < n; first++ {
+			ntests := 0
+			good, bad, err := dnav.Bisect(n, func(i int) (int, error) {
+				ntests++
+				if i >= first {
+					return dnav.Bad, nil
+				}
+				return dnav.Good, nil
+			})
+			if err != nil || bad != first || good != first-1 {
+				t.Fatalf("n %d: should find %d, found %d %d %v", n, first, good, bad, err)
+			}
+			if ntests > 8 {
+				t.Fatalf("n %d: too many tests %d", n, ntests)
+			}
+		}
+	}
+	//the skipped ones are left between the good and the bad
+	skip := map[int]bool{4: true, 5: true, 6: true}
+	good, bad, err := dnav.Bisect(10, func(i int) (int, error) {
+		switch {
+		case skip[i]:
+			return dnav.Skip, nil
+		case i >= 5:
+			return dnav.Bad, nil
+		}
+		return dnav.Good, nil
+	})
+	if err != nil || good != 3 || bad != 7 {
+		t.Fatalf("should find between 3 and 7: %d %d %v", good, bad, err)
+	}
+	_, _, err = dnav.Bisect(5, func(i int) (int, error) { return dnav.Good, nil })
+	if err == nil {
+		t.Fatalf("should error, the last is not bad")
+	}
+}
@@ -0,0 +1,373 @@
+package dnav
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+//DefMaxSize is the size in bytes of the biggest files read in memory to diff them, by default
+const DefMaxSize = 8 * 1024 * 1024
+
+//A Comparer finds if two versions of a path of the dump are the same as hist(1) does,
+//going from cheap to expensive. It can be used concurrently.
+type Comparer struct {
+	Roots   Roots
+	Index   *Index      //where the information and sums of the files are cached, may be nil
+	NoHash  bool        //files with the same size and different mtimes are different, without hashing them
+	MaxSize int64       //files bigger than this are not read in memory, only hashed as streams
+	Norm    *Normalizer //text files which only differ in what it ignores are the same version, may be nil
+	Confine bool        //the paths whose symbolic links lead out of their snapshot are taken as missing
+}
+
+//NewComparer returns a Comparer for the dump which hashes the files and does not normalize them.
+//It confines the paths to their snapshots, to serve the dump.
+func NewComparer(roots Roots) *Comparer {
+	return &Comparer{Roots: roots, MaxSize: DefMaxSize, Confine: true}
+}
+
+//A File is a version of a path of the dump, its content is only read when it is needed
+type File struct {
+	Path  string
+	Info  os.FileInfo
+	Txt   string   //the content, once loaded, or the listing of a directory
+	Lines []string //the lines of Txt
+	Sum   [32]byte //sha256 of Txt, once hashed
+
+	loaded bool
+	hashed bool
+	c      *Comparer
+}
+
+func (f *File) String() string {
+	s := fmt.Sprintf("%s ", f.Path)
+	if f.Info == nil {
+		s += " ###bad info###"
+		return s
+	}
+	s += fmt.Sprintf("%d ", f.Info.Size())
+	s += fmt.Sprintf("%#o ", f.Info.Mode())
+	s += fmt.Sprintf("%v ", f.Info.ModTime())
+	if f.Info.IsDir() {
+		s += fmt.Sprintf(" d")
+	} else {
+		s += fmt.Sprintf(" f")
+	}
+
+	return s
+}
+
+//IsText is true for the files loaded which are not binary
+func (f *File) IsText() bool {
+	return !strings.ContainsRune(f.Txt, utf8.RuneError)
+}
+
+//IsDir is true for directories
+func (f *File) IsDir() bool {
+	return f.Info != nil && f.Info.IsDir()
+}
+
+//isSpecial is true for the files which are neither regular nor directories, which are
+//not read (they could be fifos) and are only compared by their information
+func (f *File) isSpecial() bool {
+	return f.Info != nil && !f.Info.IsDir() && !f.Info.Mode().IsRegular()
+}
+
+//TooBig is true for the files we do not want to hold in memory to diff them
+func (f *File) TooBig() bool {
+	return !f.IsDir() && f.Info.Size() > f.c.MaxSize
+}
+
+func (f *File) readDir() (txt string, err error) {
+	files, err := ioutil.ReadDir(f.Path)
+	if err != nil {
+		return "", err
+	}
+	for _, fi := range files {
+		fp := &File{Path: fi.Name(), Info: fi}
+		txt += fmt.Sprintf("\t[]\t%s\n", fp)
+	}
+	return txt, nil
+}
+
+//confined finds if the symbolic links of a path of the dump stay in its snapshot
+func (c *Comparer) confined(path string) (bool, error) {
+	if !IsDump(path, c.Roots) {
+		return true, nil
+	}
+	d, err := ParseDumpPath(path, c.Roots)
+	if err != nil {
+		return false, err
+	}
+	rel, err := RelPath(path, c.Roots)
+	if err != nil {
+		return false, err
+	}
+	s := Snapshot{c.Roots.DumpRoot + "/" + d.Name(), d}
+	_, _, err = s.Resolve(rel, c.Roots)
+	switch {
+	case err == ErrOutside:
+		Dprintf("%s: %s\n", path, err)
+		return false, nil
+	case err != nil && !os.IsNotExist(err):
+		return false, err
+	}
+	return true, nil
+}
+
+//ReadFile only stats regular files, their content is read when
+//it is really needed, see Load and SameVersion. Directories are read.
+func (c *Comparer) ReadFile(path string) (f *File, exists bool, err error) {
+	f = &File{Path: path, c: c}
+	if c.Confine {
+		ok, err := c.confined(path)
+		if !ok || err != nil {
+			return f, false, err
+		}
+	}
+	f.Info, err = os.Stat(f.Path)
+	if os.IsNotExist(err) {
+		return f, false, nil
+	}
+	if err != nil {
+		return f, false, err
+	}
+	exists = true
+	if f.Info.IsDir() {
+		f.Txt, err = f.readDir()
+		if err != nil {
+			return f, exists, err
+		}
+		f.Sum = sha256.Sum256([]byte(f.Txt)) //BETTER WAYS md5? no sec concern here, which is faster?
+		f.Lines = strings.Split(f.Txt, "\n")
+		f.loaded = true
+		f.hashed = true
+	}
+	return f, exists, nil
+}
+
+//newFile is the File of a path of the dump whose information is known
+func (c *Comparer) newFile(path string, fi os.FileInfo) *File {
+	return &File{Path: path, Info: fi, c: c}
+}
+
+//Load reads the content of the file (once)
+func (f *File) Load() error {
+	if f.loaded {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return err
+	}
+	f.Txt = string(buf)
+	f.Sum = sha256.Sum256(buf) //BETTER WAYS? no sec concern here, which is faster?
+	f.Lines = strings.Split(f.Txt, "\n")
+	f.loaded = true
+	f.hashed = true
+	f.remember()
+	return nil
+}
+
+//Hash computes the sha of the file (once) reading it as a stream,
+//so that it does not need to fit in memory. The sum in the manifest
+//of the snapshot is trusted, if there is one.
+func (f *File) Hash() error {
+	if f.hashed {
+		return nil
+	}
+	if f.c.Index != nil {
+		e, ok := f.c.Index.Lookup(f.Path)
+		if ok && e.Hashed && e.Size == f.Info.Size() && e.ModTime.Equal(f.Info.ModTime()) {
+			f.Sum = e.Sum
+			f.hashed = true
+			return nil
+		}
+	}
+	if sum, ok := ManifestSum(f.Path, f.Info, f.c.Roots); ok {
+		f.Sum = sum
+		f.hashed = true
+		f.remember()
+		return nil
+	}
+	sum, err := HashFile(f.Path)
+	if err != nil {
+		return err
+	}
+	f.Sum = sum
+	f.hashed = true
+	f.remember()
+	return nil
+}
+
+//Unload forgets the content of a regular file once it is not needed, it is read again by Load
+func (f *File) Unload() {
+	if f.loaded && !f.IsDir() {
+		f.Txt, f.Lines, f.loaded = "", nil, false
+	}
+}
+
+//Hashed is true if the sum of the file is known
+func (f *File) Hashed() bool {
+	return f.hashed
+}
+
+//Fetch is ReadFile, but regular files and missing paths
+//which are in the index are not looked up in the dump
+func (c *Comparer) Fetch(path string) (f *File, exists bool, err error) {
+	if c.Index != nil {
+		if e, ok := c.Index.Lookup(path); ok {
+			f = &File{Path: path, Info: e.FileInfo(path), c: c}
+			if e.Hashed {
+				f.Sum = e.Sum
+				f.hashed = true
+			}
+			return f, e.Exists, nil
+		}
+	}
+	f, exists, err = c.ReadFile(path)
+	if err == nil && !f.IsDir() {
+		f.remember()
+	}
+	return f, exists, err
+}
+
+//remember records the file in the index, if there is one
+func (f *File) remember() {
+	if f.c.Index == nil || f.IsDir() {
+		return
+	}
+	e := NewEntry(f.Info)
+	if f.hashed {
+		e.Sum = f.Sum
+		e.Hashed = true
+	}
+	f.c.Index.Add(f.Path, e)
+}
+
+//QuickSame is the part of SameVersion which does not need to read the files,
+//known is false if the contents have to be hashed
+func (f *File) QuickSame(f2 *File) (same bool, known bool) {
+	if f.IsDir() || f2.IsDir() {
+		return f.IsDir() == f2.IsDir() && f.Sum == f2.Sum, true
+	}
+	if os.SameFile(f.Info, f2.Info) {
+		Dprintf("same file %s %s\n", f.Path, f2.Path)
+		return true, true
+	}
+	if f.Info.Size() != f2.Info.Size() {
+		return false, true
+	}
+	if f.Info.ModTime().Equal(f2.Info.ModTime()) {
+		Dprintf("same size and mtime %s %s\n", f.Path, f2.Path)
+		return true, true
+	}
+	if f.c.NoHash || f.isSpecial() || f2.isSpecial() {
+		return false, true
+	}
+	return false, false
+}
+
+//sameContent is SameVersion comparing the contents as they are
+func (f *File) sameContent(f2 *File) (bool, error) {
+	if same, known := f.QuickSame(f2); known {
+		return same, nil
+	}
+	if err := f.Hash(); err != nil {
+		return false, err
+	}
+	if err := f2.Hash(); err != nil {
+		return false, err
+	}
+	return f.Sum == f2.Sum, nil
+}
+
+//SameVersion finds if two files have the same content going from cheap to
+//expensive: same file (hard links in the dump), then size and mtime and only
+//then reading and hashing the content, unless NoHash is set. Text files
+//which differ only in what the normalizer ignores are the same version.
+func (f *File) SameVersion(f2 *File) (bool, error) {
+	same, err := f.sameContent(f2)
+	if same || err != nil || f.c.Norm == nil || f.IsDir() || f2.IsDir() ||
+		f.isSpecial() || f2.isSpecial() || f.TooBig() || f2.TooBig() {
+		return same, err
+	}
+	if err := f.Load(); err != nil {
+		return false, err
+	}
+	if err := f2.Load(); err != nil {
+		return false, err
+	}
+	return f.IsText() && f2.IsText() && f.c.Norm.Text(f.Txt) == f.c.Norm.Text(f2.Txt), nil
+}
+
+//SameStat is true if the size, mode and modification time of the files are the same, to tell a
+//Wstat from no change. Unlike SameInfo, which tells if the contents can be taken to be the
+//same without reading them, it compares the mode too and says nothing of the contents.
+func (f *File) SameStat(f2 *File) bool {
+	fi, fi2 := f.Info, f2.Info
+	return fi.Size() == fi2.Size() && fi.Mode() == fi2.Mode() && fi.ModTime().Equal(fi2.ModTime())
+}
+
+//parDo calls fn for 0 <= i < n, with at most nProcs calls running at the same time
+func parDo(n int, nProcs int, fn func(i int)) {
+	if nProcs < 1 {
+		nProcs = 1
+	}
+	sem := make(chan struct{}, nProcs)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			fn(i)
+			<-sem
+		}(i)
+	}
+	wg.Wait()
+}
+
+//A Fetched is a path fetched by Prefetch
+type Fetched struct {
+	File   *File
+	Exists bool
+	Err    error
+}
+
+//Prefetch fetches up to nProcs of the versions of a file at the same time, then hashes
+//the ones which SameVersion cannot tell apart without reading them in the same way.
+//The results are in the same order as the paths.
+func (c *Comparer) Prefetch(paths []string, nProcs int) []Fetched {
+	fs := make([]Fetched, len(paths))
+	parDo(len(paths), nProcs, func(i int) {
+		f := &fs[i]
+		f.File, f.Exists, f.Err = c.Fetch(paths[i])
+	})
+	var toHash []*File
+	var last *File
+	for _, f := range fs {
+		if !f.Exists || f.Err != nil {
+			continue
+		}
+		if last != nil {
+			if _, known := last.QuickSame(f.File); !known {
+				if len(toHash) == 0 || toHash[len(toHash)-1] != last {
+					toHash = append(toHash, last)
+				}
+				toHash = append(toHash, f.File)
+			}
+		}
+		last = f.File
+	}
+	parDo(len(toHash), nProcs, func(i int) {
+		if err := toHash[i].Hash(); err != nil {
+			Dprintf("prefetch %s: %s\n", toHash[i].Path, err)
+		}
+	})
+	return fs
+}
@@ -0,0 +1,75 @@
+package dnav_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestSameVersion(t *testing.T) {
+	var r dnav.Roots
+	tmproot := TmpDumpRootBase + "compare"
+	os.RemoveAll(tmproot)
+	defer os.RemoveAll(tmproot)
+	os.MkdirAll(tmproot+"/live", 0755)
+	os.MkdirAll(tmproot+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, tmproot+"/live")
+	os.Setenv(dnav.MainDumpVar, tmproot+"/dump")
+	dnav.RdRoots(&r)
+
+	mtime := time.Date(2017, 1, 1, 0, 0, 0, 0, time.Local)
+	files := []string{"one two\n", "one two\n", "one  two\n", "one tw0\n"}
+	var paths []string
+	for i, txt := range files {
+		p := tmproot + "/live/" + string(rune('a'+i))
+		os.WriteFile(p, []byte(txt), 0644)
+		os.Chtimes(p, mtime.Add(time.Duration(i)*time.Hour), mtime.Add(time.Duration(i)*time.Hour))
+		paths = append(paths, p)
+	}
+	paths = append(paths, tmproot+"/live/doesnotexist")
+
+	c := dnav.NewComparer(r)
+	fs := c.Prefetch(paths, 2)
+	if len(fs) != len(paths) {
+		t.Fatalf("should fetch every path, got %d", len(fs))
+	}
+	for i, f := range fs {
+		if f.Err != nil || f.Exists != (i < len(files)) || f.File.Path != paths[i] {
+			t.Fatalf("bad fetch of %s: %v %v", paths[i], f.Exists, f.Err)
+		}
+	}
+	if !fs[0].File.Hashed() || !fs[1].File.Hashed() {
+		t.Errorf("should hash the files with the same size and different mtimes")
+	}
+	same := func(c *dnav.Comparer, i int, j int) bool {
+		f, _, err := c.ReadFile(paths[i])
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		f2, _, err := c.ReadFile(paths[j])
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		s, err := f.SameVersion(f2)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		return s
+	}
+	if !same(c, 0, 1) || same(c, 1, 2) || same(c, 2, 3) {
+		t.Errorf("should compare the contents")
+	}
+	if same(&dnav.Comparer{Roots: r, NoHash: true}, 0, 1) {
+		t.Errorf("should take the same size and different mtimes as different with NoHash")
+	}
+	c.Norm = &dnav.Normalizer{SpaceChange: true}
+	if !same(c, 1, 2) || same(c, 2, 3) {
+		t.Errorf("should compare the contents as normalized")
+	}
+	c.MaxSize = 4
+	if same(c, 1, 2) {
+		t.Errorf("should not normalize the files bigger than MaxSize")
+	}
+}
@@ -0,0 +1,169 @@
+package dnav
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+//FmtDiff formats the differences between the lines of two files
+//in the format used by hist(1)
+func FmtDiff(diffs []diffmatchpatch.Diff, path string, lines []string, path2 string, lines2 []string) string {
+	var buff bytes.Buffer
+	nlRight := 0
+	nlLeft := 0
+	Dprintf("\nDIFFs--\n")
+	for _, diff := range diffs {
+		text := diff.Text
+		nlDiff := strings.Count(text, "\n")
+		if len(text) == 0 {
+			continue
+		}
+		diffStr := ""
+		switch diff.Type {
+		case diffmatchpatch.DiffInsert:
+			Dprintf(">--insert %d, (%d %d)\n", nlDiff, nlLeft, nlRight)
+			buff.WriteString(fmt.Sprintf("\n%s:%d,%d %s:%d,%d\n", path, nlLeft+1, nlLeft+1, path2, nlRight+1, nlRight+nlDiff+1))
+			for i := nlRight; i < nlRight+nlDiff-1; i++ {
+				diffStr += ">" + lines2[i] + "\n"
+			}
+			if nlDiff == 0 {
+				//diffStr += "^" + text + "^" + "\n"
+				diffStr += ">" + lines2[nlRight] + "\n" //TODO: SHOULD MERGE continuous runs
+			}
+			nlRight += nlDiff
+		case diffmatchpatch.DiffDelete:
+			Dprintf("<--delete %d, (%d %d)\n", nlDiff, nlLeft, nlRight)
+			buff.WriteString(fmt.Sprintf("\n%s:%d,%d %s:%d,%d\n", path, nlLeft+1, nlLeft+nlDiff+1, path2, nlRight+1, nlRight+1))
+			for i := nlLeft; i < nlLeft+nlDiff; i++ {
+				diffStr += "<" + lines[i] + "\n"
+			}
+			if nlDiff == 0 {
+				//diffStr += "^" + text + "^" + "\n"
+				diffStr += "<" + lines[nlLeft] + "\n" //TODO: SHOULD MERGE continuous runs
+			}
+			nlLeft += nlDiff
+		case diffmatchpatch.DiffEqual:
+			Dprintf("=--match %d, (%d %d)\n", nlDiff, nlLeft, nlRight)
+			nlLeft += nlDiff
+			nlRight += nlDiff
+		}
+		if diff.Type != diffmatchpatch.DiffEqual {
+			_, _ = buff.WriteString("\n" + diffStr)
+		}
+
+	}
+
+	return buff.String()
+}
+
+//Diff returns the differences between two texts in the format used by hist(1)
+func Diff(path string, txt string, path2 string, txt2 string) string {
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMain(txt, txt2, true)
+	diffs = dmp.DiffCleanupSemantic(diffs)
+	return FmtDiff(diffs, path, strings.Split(txt, "\n"), path2, strings.Split(txt2, "\n"))
+}
+
+//A DiffRow is a row of a side by side diff. The line numbers start at 1,
+//0 if the side has no line in the row.
+type DiffRow struct {
+	Line  int
+	Text  string
+	Line2 int
+	Text2 string
+	Equal bool
+}
+
+//splitLines splits a text in lines, without an empty last one
+func splitLines(txt string) []string {
+	if txt == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(txt, "\n"), "\n")
+}
+
+//linesToRunes encodes each distinct line of both texts as a rune, so that
+//they can be diffed line by line (DiffLinesToChars gives different runes to
+//the same line in each text)
+func linesToRunes(ls []string, ls2 []string) (r []rune, r2 []rune, lines []string) {
+	codes := make(map[string]rune)
+	encode := func(ls []string) (rs []rune) {
+		for _, l := range ls {
+			c, ok := codes[l]
+			if !ok {
+				c = rune(len(lines))
+				if c >= 0xd800 {
+					c += 0x800 //not a surrogate
+				}
+				codes[l] = c
+				lines = append(lines, l)
+			}
+			rs = append(rs, c)
+		}
+		return rs
+	}
+	r = encode(ls)
+	r2 = encode(ls2)
+	return r, r2, lines
+}
+
+//runeLine decodes a line encoded by linesToRunes
+func runeLine(lines []string, c rune) string {
+	if c >= 0xd800+0x800 {
+		c -= 0x800
+	}
+	return lines[c]
+}
+
+//SideBySide compares two texts line by line and returns the rows of a side by side diff,
+//deleted lines are paired with the lines inserted in their place
+func SideBySide(txt string, txt2 string) (rows []DiffRow) {
+	dmp := diffmatchpatch.New()
+	a, b, lines := linesToRunes(splitLines(txt), splitLines(txt2))
+	diffs := dmp.DiffMainRunes(a, b, false)
+	line := func(c rune) string {
+		return runeLine(lines, c)
+	}
+	nl, nl2 := 0, 0
+	var deleted []string
+	flush := func() {
+		for _, l := range deleted {
+			nl++
+			rows = append(rows, DiffRow{Line: nl, Text: l})
+		}
+		deleted = nil
+	}
+	for _, diff := range diffs {
+		switch diff.Type {
+		case diffmatchpatch.DiffDelete:
+			flush()
+			for _, c := range diff.Text {
+				deleted = append(deleted, line(c))
+			}
+		case diffmatchpatch.DiffInsert:
+			for _, c := range diff.Text {
+				row := DiffRow{Line2: nl2 + 1, Text2: line(c)}
+				if len(deleted) > 0 {
+					nl++
+					row.Line, row.Text = nl, deleted[0]
+					deleted = deleted[1:]
+				}
+				nl2++
+				rows = append(rows, row)
+			}
+			flush()
+		case diffmatchpatch.DiffEqual:
+			flush()
+			for _, c := range diff.Text {
+				nl++
+				nl2++
+				rows = append(rows, DiffRow{nl, line(c), nl2, line(c), true})
+			}
+		}
+	}
+	flush()
+	return rows
+}
@@ -0,0 +1,42 @@
+package dnav_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestDiff(t *testing.T) {
+	txt := "one\ntwo\nthree\n"
+	if d := dnav.Diff("a", txt, "b", txt); d != "" {
+		t.Fatalf("should be no diffs: %s", d)
+	}
+	d := dnav.Diff("a", txt, "b", "one\nthree\n")
+	if !strings.Contains(d, "a:2,3 b:2,2") || !strings.Contains(d, "<two\n") {
+		t.Fatalf("bad diff: [%s]", d)
+	}
+}
+
+func TestSideBySide(t *testing.T) {
+	rows := dnav.SideBySide("one\ntwo\nthree\nfour\n", "one\n2\nthree\nfour\nfive\n")
+	want := []dnav.DiffRow{
+		{1, "one", 1, "one", true},
+		{2, "two", 2, "2", false},
+		{3, "three", 3, "three", true},
+		{4, "four", 4, "four", true},
+		{0, "", 5, "five", false},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("bad rows %v", rows)
+	}
+	for i := range rows {
+		if rows[i] != want[i] {
+			t.Fatalf("bad row %v, should be %v", rows[i], want[i])
+		}
+	}
+	rows = dnav.SideBySide("a\nb\nc\n", "c\n")
+	if len(rows) != 3 || rows[0].Line2 != 0 || rows[1].Text != "b" || !rows[2].Equal {
+		t.Fatalf("bad rows %v", rows)
+	}
+}
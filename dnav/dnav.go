@@ -137,6 +137,16 @@ func TimeAddDate(t time.Time, deltaT DumpDate) DumpDate {
 	return TInDumpDate(t2)
 }
 
+//Time converts a DumpDate into a time in the local time zone
+func (d *DumpDate) Time() time.Time {
+	return time.Date(d.years, time.Month(d.months), d.days, d.hours/100, d.hours%100, 0, 0, time.Local)
+}
+
+//Name is the path of the snapshot for the DumpDate relative to the dump root, yyyy/mmdd/hhmm
+func (d *DumpDate) Name() string {
+	return fmt.Sprintf("%4.4d/%2.2d%2.2d/%4.4d", d.years, d.months, d.days, d.hours)
+}
+
 //for a set of paths separated by colons find the first that exists
 func firstExists(paths string) string {
 	Dprintf("checking [%s]\n", paths)
@@ -217,6 +227,56 @@ func ParseDumpPath(path string, roots Roots) (d DumpDate, err error) {
 	return d, err
 }
 
+var dateLayouts = []string{"2006-01-02T15:04", "2006-01-02T1504", "2006-01-02"}
+
+//parseDate is ParseDate, but it also returns how many of
+//the parts of the date (year, day, time) were given
+func parseDate(s string, roots Roots) (d DumpDate, nParts int, err error) {
+	if !strings.HasPrefix(s, "/") && !strings.Contains(s, "-") {
+		s = roots.DumpRoot + "/" + s
+	}
+	if strings.HasPrefix(s, "/") {
+		p := strings.Trim(strings.TrimPrefix(s, roots.DumpRoot), "/")
+		nParts = len(strings.Split(p, "/"))
+		d, err = ParseDumpPath(s, roots)
+		return d, nParts, err
+	}
+	for i, l := range dateLayouts {
+		if t, err := time.ParseInLocation(l, s, time.Local); err == nil {
+			if i == len(dateLayouts)-1 {
+				return TInDumpDate(t), 2, nil
+			}
+			return TInDumpDate(t), 3, nil
+		}
+	}
+	return d, 0, errors.New("bad date " + s)
+}
+
+//ParseDate interprets a date given by the user, as a path in the dump,
+//a snapshot name yyyy/mmdd/hhmm (where the smallest values may be missing)
+//or a time yyyy-mm-dd[Thh:mm]. The missing values are zero.
+func ParseDate(s string, roots Roots) (d DumpDate, err error) {
+	d, _, err = parseDate(s, roots)
+	return d, err
+}
+
+//ParseDateEnd is like ParseDate, but the missing values are the last ones,
+//so that the date can be used as the end of a period
+func ParseDateEnd(s string, roots Roots) (d DumpDate, err error) {
+	d, nParts, err := parseDate(s, roots)
+	if err != nil {
+		return d, err
+	}
+	if nParts < 2 {
+		d.months = 12
+		d.days = 31
+	}
+	if nParts < 3 {
+		d.hours = 2359
+	}
+	return d, nil
+}
+
 //given a path, find the biggest numeric name smaller than a number
 func biggestSmallerEqthan(path string, max int) (curr int, err error) {
 	var files []os.FileInfo
@@ -229,7 +289,7 @@ func biggestSmallerEqthan(path string, max int) (curr int, err error) {
 	//they are sorted by filename
 	for _, file := range files {
 		switch file.Name() {
-		case "current", "current_chk", "first", "lost+found":
+		case CurrentMarker, CurrentChkMarker, FirstMarker, TakingDir, "lost+found":
 			continue
 		default:
 			break
@@ -123,3 +123,45 @@ func TestFindDump(t *testing.T) {
 	}
 	os.RemoveAll(tmproot)
 }
+
+func TestParseDate(t *testing.T) {
+	var r dnav.Roots
+	os.Setenv(dnav.MainRootVar, "/adfadf:/2rsdfewr2/asf3qer:/bin")
+	os.Setenv(dnav.MainDumpVar, "/adf13123adf:/sdfsd/asf3qer:/etc")
+	dnav.RdRoots(&r)
+
+	dates := []struct {
+		s     string
+		start *dnav.DumpDate
+		end   *dnav.DumpDate
+	}{
+		{GoodDumpPath, dnav.NewDumpDate(2017, 4, 15, 36), dnav.NewDumpDate(2017, 4, 15, 36)},
+		{"2017/0415/0036", dnav.NewDumpDate(2017, 4, 15, 36), dnav.NewDumpDate(2017, 4, 15, 36)},
+		{"2017/0415", dnav.NewDumpDate(2017, 4, 15, 0), dnav.NewDumpDate(2017, 4, 15, 2359)},
+		{"2017", dnav.NewDumpDate(2017, 0, 0, 0), dnav.NewDumpDate(2017, 12, 31, 2359)},
+		{"2017-04-15T00:36", dnav.NewDumpDate(2017, 4, 15, 36), dnav.NewDumpDate(2017, 4, 15, 36)},
+		{"2017-04-15", dnav.NewDumpDate(2017, 4, 15, 0), dnav.NewDumpDate(2017, 4, 15, 2359)},
+	}
+	for _, d := range dates {
+		start, err := dnav.ParseDate(d.s, r)
+		if err != nil || start != *d.start {
+			t.Fatalf("bad date %s: %s %s", d.s, &start, err)
+		}
+		end, err := dnav.ParseDateEnd(d.s, r)
+		if err != nil || end != *d.end {
+			t.Fatalf("bad end date %s: %s %s", d.s, &end, err)
+		}
+	}
+	for _, s := range []string{"2017-04-15X", BadDumpPath, BadDumpPathRoot} {
+		if _, err := dnav.ParseDate(s, r); err == nil {
+			t.Fatalf("should error, bad date %s", s)
+		}
+	}
+	d := dnav.NewDumpDate(2017, 4, 15, 36)
+	if d.Name() != "2017/0415/0036" {
+		t.Fatalf("bad name %s for %s", d.Name(), d)
+	}
+	if tm := d.Time(); tm.Year() != 2017 || tm.Month() != 4 || tm.Day() != 15 || tm.Hour() != 0 || tm.Minute() != 36 {
+		t.Fatalf("bad time %s for %s", tm, d)
+	}
+}
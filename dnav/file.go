@@ -0,0 +1,39 @@
+package dnav
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+)
+
+//A FileID identifies a file in a system, files with the same
+//FileID are hard links to the same file
+type FileID struct {
+	Dev uint64
+	Ino uint64
+}
+
+//HashFile computes the sha256 of the content of a file reading it as a stream
+func HashFile(path string) (sum [32]byte, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+//SameInfo reports if two regular files can be taken to have the same content without
+//reading them, because they are the same file (hard links between dumps) or they
+//have the same size and modification time. See File.SameStat for the metadata of versions.
+func SameInfo(fi os.FileInfo, fi2 os.FileInfo) bool {
+	if os.SameFile(fi, fi2) {
+		return true
+	}
+	return fi.Size() == fi2.Size() && fi.ModTime().Equal(fi2.ModTime())
+}
@@ -0,0 +1,53 @@
+package dnav_test
+
+import (
+	"crypto/sha256"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestHashFile(t *testing.T) {
+	tmpdir := TmpDumpRootBase + "hashfile"
+	os.RemoveAll(tmpdir)
+	os.Mkdir(tmpdir, 0700)
+	defer os.RemoveAll(tmpdir)
+
+	content := []byte("one\ntwo\n")
+	f1, f2, f3 := tmpdir+"/f1", tmpdir+"/f2", tmpdir+"/f3"
+	os.WriteFile(f1, content, 0600)
+	os.Link(f1, f2)
+	os.WriteFile(f3, content, 0600)
+	mtime := time.Now().Add(-time.Hour)
+	os.Chtimes(f3, mtime, mtime)
+
+	sum, err := dnav.HashFile(f1)
+	if err != nil || sum != sha256.Sum256(content) {
+		t.Fatalf("bad sum for %s: %s", f1, err)
+	}
+	if _, err := dnav.HashFile(tmpdir + "/doesnotexist"); err == nil {
+		t.Fatalf("should error, file does not exist")
+	}
+	fi1, _ := os.Stat(f1)
+	fi2, _ := os.Stat(f2)
+	fi3, _ := os.Stat(f3)
+	if !dnav.SameInfo(fi1, fi2) {
+		t.Fatalf("hard links should be the same")
+	}
+	if dnav.SameInfo(fi1, fi3) {
+		t.Fatalf("files with different mtime should not be the same")
+	}
+	id1, ok1 := dnav.FileIDOf(fi1)
+	id2, ok2 := dnav.FileIDOf(fi2)
+	id3, ok3 := dnav.FileIDOf(fi3)
+	if ok1 && ok2 && ok3 && (id1 != id2 || id1 == id3) {
+		t.Fatalf("bad file ids %v %v %v", id1, id2, id3)
+	}
+	os.Chtimes(f1, mtime, mtime)
+	fi1, _ = os.Stat(f1)
+	if !dnav.SameInfo(fi1, fi3) {
+		t.Fatalf("files with the same size and mtime should be the same")
+	}
+}
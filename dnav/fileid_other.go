@@ -0,0 +1,15 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package dnav
+
+import "os"
+
+//FileIDOf returns the device and inode of a file, ok is false if they are not known
+func FileIDOf(fi os.FileInfo) (id FileID, ok bool) {
+	return id, false
+}
+
+//FileOwner returns the user and group owning a file, ok is false if they are not known
+func FileOwner(fi os.FileInfo) (uid int, gid int, ok bool) {
+	return -1, -1, false
+}
@@ -0,0 +1,26 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package dnav
+
+import (
+	"os"
+	"syscall"
+)
+
+//FileIDOf returns the device and inode of a file, ok is false if they are not known
+func FileIDOf(fi os.FileInfo) (id FileID, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return id, false
+	}
+	return FileID{uint64(st.Dev), uint64(st.Ino)}, true
+}
+
+//FileOwner returns the user and group owning a file, ok is false if they are not known
+func FileOwner(fi os.FileInfo) (uid int, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
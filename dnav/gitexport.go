@@ -0,0 +1,177 @@
+package dnav
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//Committer of the commits exported by ExportGit
+const GitCommitter = "dump <dump@localhost>"
+
+//A gitFile is a file exported to git, with its path in the snapshot
+type gitFile struct {
+	path string
+	fi   os.FileInfo
+}
+
+//gitMode is the mode of the file in git, "" for the files git cannot hold
+func gitMode(fi os.FileInfo) string {
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		return "120000"
+	case !fi.Mode().IsRegular():
+		return ""
+	case fi.Mode()&0111 != 0:
+		return "100755"
+	}
+	return "100644"
+}
+
+//gitTree returns the files under rel in the snapshot, by their path relative to rel,
+//or by the name of rel if it is not a directory. Directories are not kept, git has no
+//empty directories, and neither are special files.
+func gitTree(s *Snapshot, roots Roots, rel string) (files map[string]gitFile, err error) {
+	files = make(map[string]gitFile)
+	err = WalkSnapshot(s, roots, rel, nil, func(r string, fi os.FileInfo) error {
+		if fi.IsDir() || gitMode(fi) == "" {
+			return nil
+		}
+		name := strings.TrimPrefix(r, rel+"/")
+		if r == rel {
+			name = filepath.Base(r)
+		}
+		files[name] = gitFile{s.PathOf(r, roots), fi}
+		return nil
+	})
+	return files, err
+}
+
+//sameGitFile finds if a file is unchanged for git, same mode and content as they are
+func sameGitFile(f gitFile, f2 gitFile, c *Comparer) (bool, error) {
+	if gitMode(f.fi) != gitMode(f2.fi) {
+		return false, nil
+	}
+	if f.fi.Mode()&os.ModeSymlink != 0 {
+		l, err := os.Readlink(f.path)
+		if err != nil {
+			return false, err
+		}
+		l2, err := os.Readlink(f2.path)
+		return l == l2, err
+	}
+	return c.newFile(f.path, f.fi).sameContent(c.newFile(f2.path, f2.fi))
+}
+
+//gitQuote quotes a path for fast-import if it needs it, as git does
+func gitQuote(p string) string {
+	if !strings.ContainsAny(p, "\"\\\n") && !strings.HasPrefix(p, " ") {
+		return p
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString("\\n")
+		case c < ' ':
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+//writeGitData writes the content of a file as fast-import data
+func writeGitData(w *bufio.Writer, f gitFile) error {
+	if f.fi.Mode()&os.ModeSymlink != 0 {
+		l, err := os.Readlink(f.path)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "data %d\n%s\n", len(l), l)
+		return err
+	}
+	fd, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fmt.Fprintf(w, "data %d\n", f.fi.Size())
+	if _, err := io.CopyN(w, fd, f.fi.Size()); err != nil {
+		return fmt.Errorf("%s: %s", f.path, err)
+	}
+	return w.WriteByte('\n')
+}
+
+//ExportGit writes to w a git fast-import stream of the history of the path rel in the
+//snapshots, a file or a directory, into the branch ref (like refs/heads/master). Each
+//snapshot in which the files changed is a commit dated at the snapshot, the others are
+//skipped, c tells which changed (its normalizer is not used, contents are exported as
+//they are). It returns the number of commits.
+func ExportGit(w io.Writer, snaps []Snapshot, c *Comparer, rel string, ref string) (n int, err error) {
+	roots := c.Roots
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "feature done\n")
+	var last map[string]gitFile
+	for i := range snaps {
+		s := &snaps[i]
+		files, err := gitTree(s, roots, rel)
+		if err != nil {
+			return n, err
+		}
+		var changed, deleted []string
+		for name, f := range files {
+			lf, ok := last[name]
+			if ok {
+				same, err := sameGitFile(lf, f, c)
+				if err != nil {
+					return n, err
+				}
+				if same {
+					continue
+				}
+			}
+			changed = append(changed, name)
+		}
+		for name := range last {
+			if _, ok := files[name]; !ok {
+				deleted = append(deleted, name)
+			}
+		}
+		last = files
+		if len(changed) == 0 && len(deleted) == 0 {
+			Dprintf("export: %s unchanged\n", s.Path)
+			continue
+		}
+		sort.Strings(changed)
+		sort.Strings(deleted)
+		t := s.Date.Time()
+		msg := fmt.Sprintf("dump %s of %s\n", s.Date.Name(), roots.RootName+rel)
+		fmt.Fprintf(bw, "commit %s\nmark :%d\ncommitter %s %d %s\ndata %d\n%s",
+			ref, n+1, GitCommitter, t.Unix(), t.Format("-0700"), len(msg), msg)
+		for _, name := range deleted {
+			fmt.Fprintf(bw, "D %s\n", gitQuote(name))
+		}
+		for _, name := range changed {
+			f := files[name]
+			fmt.Fprintf(bw, "M %s inline %s\n", gitMode(f.fi), gitQuote(name))
+			if err := writeGitData(bw, f); err != nil {
+				return n, err
+			}
+		}
+		fmt.Fprintf(bw, "\n")
+		n++
+	}
+	fmt.Fprintf(bw, "done\n")
+	return n, bw.Flush()
+}
@@ -0,0 +1,80 @@
+package dnav_test
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestExportGit(t *testing.T) {
+	var r dnav.Roots
+	tmproot := TmpDumpRootBase + "gitexport"
+	os.RemoveAll(tmproot)
+	defer os.RemoveAll(tmproot)
+	live := tmproot + "/live"
+	os.MkdirAll(live+"/d/sub", 0755)
+	os.MkdirAll(tmproot+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmproot+"/dump")
+	dnav.RdRoots(&r)
+
+	changes := []func(){
+		func() {
+			os.WriteFile(live+"/d/f.txt", []byte("one\n"), 0644)
+			os.WriteFile(live+"/d/sub/x.sh", []byte("echo\n"), 0755)
+		},
+		func() {},
+		func() { os.WriteFile(live+"/d/f.txt", []byte("two\n"), 0644); os.Symlink("f.txt", live+"/d/l") },
+		func() { os.Remove(live + "/d/sub/x.sh") },
+	}
+	t1 := time.Date(2017, 5, 3, 10, 30, 0, 0, time.Local)
+	for i, change := range changes {
+		change()
+		if _, _, err := dnav.TakeSnapshot(r, t1.Add(time.Duration(i)*time.Hour), nil); err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+	}
+	snaps, err := dnav.ListSnapshots(r, dnav.DumpDate{}, 1)
+	if err != nil || len(snaps) != len(changes) {
+		t.Fatalf("should list the snapshots %s", err)
+	}
+
+	var b bytes.Buffer
+	n, err := dnav.ExportGit(&b, snaps, dnav.NewComparer(r), "/d", "refs/heads/master")
+	if err != nil || n != 3 {
+		t.Fatalf("should export 3 commits: %d %s", n, err)
+	}
+	out := b.String()
+	want := []string{
+		"feature done\n",
+		"commit refs/heads/master\nmark :1\ncommitter " + dnav.GitCommitter + " " + strconv.FormatInt(t1.Unix(), 10),
+		"M 100644 inline f.txt\ndata 4\none\n\n",
+		"M 100755 inline sub/x.sh\ndata 5\necho\n\n",
+		"mark :2\n",
+		"M 100644 inline f.txt\ndata 4\ntwo\n\n",
+		"M 120000 inline l\ndata 5\nf.txt\n",
+		"mark :3\n",
+		"D sub/x.sh\n",
+		"done\n",
+	}
+	for _, w := range want {
+		i := strings.Index(out, w)
+		if i < 0 {
+			t.Fatalf("export should have %q, it is\n%s", w, out)
+		}
+		out = out[i+len(w):]
+	}
+
+	b.Reset()
+	if n, err := dnav.ExportGit(&b, snaps, dnav.NewComparer(r), "/d/f.txt", "refs/heads/f"); err != nil || n != 2 {
+		t.Fatalf("should export 2 commits of the file: %d %s", n, err)
+	}
+	if !strings.Contains(b.String(), "M 100644 inline f.txt\ndata 4\ntwo\n") {
+		t.Fatalf("bad export of the file\n%s", b.String())
+	}
+}
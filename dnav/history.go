@@ -0,0 +1,74 @@
+package dnav
+
+import (
+	"os"
+)
+
+//Kinds of changes of a path between snapshots, as printed by hist(1)
+const (
+	Create = "create"
+	Delete = "delete"
+	Write  = "write"
+	Wstat  = "wstat" //same content, different mode or modification time
+)
+
+//A Version is a change of a path in a snapshot with respect to the previous snapshot
+type Version struct {
+	Kind     string
+	Snapshot Snapshot
+	Path     string      //in the snapshot
+	Info     os.FileInfo //nil for Delete
+}
+
+//History returns the changes of the path rel in the snapshots, in order, as hist(1)
+//finds them. The path is looked up in up to nProcs snapshots at the same time.
+//A directory is written when its listing changes, with the information of its files.
+//A path whose symbolic links lead out of its snapshot is taken as missing in it.
+func History(snaps []Snapshot, roots Roots, rel string, nProcs int) (versions []Version, err error) {
+	return NewComparer(roots).History(snaps, rel, nProcs)
+}
+
+//History is the function of the same name, comparing the versions with c
+func (c *Comparer) History(snaps []Snapshot, rel string, nProcs int) (versions []Version, err error) {
+	paths := make([]string, len(snaps))
+	for i := range snaps {
+		paths[i] = snaps[i].PathOf(rel, c.Roots)
+	}
+	fs := c.Prefetch(paths, nProcs)
+	var last *File
+	for i, f := range fs {
+		if f.Err != nil {
+			return nil, f.Err
+		}
+		v := Version{Snapshot: snaps[i], Path: paths[i]}
+		switch {
+		case !f.Exists && last == nil:
+			continue
+		case !f.Exists:
+			v.Kind = Delete
+		case last == nil:
+			v.Kind = Create
+		default:
+			same, err := last.SameVersion(f.File)
+			last.Unload()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case !same:
+				v.Kind = Write
+			case !last.SameStat(f.File):
+				v.Kind = Wstat
+			default:
+				last = f.File
+				continue
+			}
+		}
+		last = nil
+		if f.Exists {
+			v.Info, last = f.File.Info, f.File
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
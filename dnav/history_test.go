@@ -0,0 +1,85 @@
+package dnav_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestHistory(t *testing.T) {
+	var r dnav.Roots
+	tmproot := TmpDumpRootBase + "history"
+	os.RemoveAll(tmproot)
+	defer os.RemoveAll(tmproot)
+	live := tmproot + "/live"
+	os.MkdirAll(live, 0755)
+	os.MkdirAll(tmproot+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmproot+"/dump")
+	dnav.RdRoots(&r)
+
+	f := live + "/f.txt"
+	mtime := time.Date(2017, 1, 1, 0, 0, 0, 0, time.Local)
+	changes := []func(){
+		func() {},
+		func() { os.WriteFile(f, []byte("one\n"), 0644) },
+		func() {},
+		func() { os.WriteFile(f, []byte("two\n"), 0644); os.Chtimes(f, mtime, mtime) },
+		func() { os.Chmod(f, 0600) },
+		func() {
+			os.WriteFile(f, []byte("two\n"), 0600)
+			os.Chtimes(f, mtime.Add(time.Hour), mtime.Add(time.Hour))
+		},
+		func() { os.Remove(f) },
+		func() {},
+		func() { os.WriteFile(f, []byte("three\n"), 0644) },
+	}
+	t1 := time.Date(2017, 5, 3, 10, 30, 0, 0, time.Local)
+	for i, change := range changes {
+		change()
+		if _, _, err := dnav.TakeSnapshot(r, t1.Add(time.Duration(i)*time.Hour), nil); err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+	}
+	snaps, err := dnav.ListSnapshots(r, dnav.DumpDate{}, 1)
+	if err != nil || len(snaps) != len(changes) {
+		t.Fatalf("should list the snapshots %s", err)
+	}
+	versions, err := dnav.History(snaps, r, "/f.txt", 4)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	want := []struct {
+		kind string
+		snap int
+	}{
+		{dnav.Create, 1},
+		{dnav.Write, 3},
+		{dnav.Wstat, 4},
+		{dnav.Wstat, 5},
+		{dnav.Delete, 6},
+		{dnav.Create, 8},
+	}
+	if len(versions) != len(want) {
+		t.Fatalf("bad versions %v", versions)
+	}
+	for i, v := range versions {
+		if v.Kind != want[i].kind || v.Snapshot.Path != snaps[want[i].snap].Path {
+			t.Fatalf("bad version %s %s, should be %s %s", v.Kind, v.Snapshot.Path, want[i].kind, snaps[want[i].snap].Path)
+		}
+		if (v.Info == nil) != (v.Kind == dnav.Delete) || v.Path != snaps[want[i].snap].PathOf("/f.txt", r) {
+			t.Fatalf("bad version %v", v)
+		}
+	}
+	if versions, err := dnav.History(snaps, r, "/doesnotexist", 1); err != nil || len(versions) != 0 {
+		t.Fatalf("should have no versions %v %s", versions, err)
+	}
+
+	//a link out of the snapshot is not followed
+	os.Symlink("/etc/passwd", snaps[2].PathOf("/out", r))
+	if versions, err := dnav.History(snaps, r, "/out", 1); err != nil || len(versions) != 0 {
+		t.Fatalf("should have no versions of a link out %v %s", versions, err)
+	}
+}
@@ -0,0 +1,283 @@
+package dnav
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const IndexVar = "DUMPINDEX"
+
+//An Entry records what a path was in a snapshot. Missing paths
+//are recorded too, so that they are not looked up again.
+type Entry struct {
+	Exists  bool
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	Sum     [32]byte //sha256 of the content, only if Hashed
+	Hashed  bool
+}
+
+//NewEntry creates an Entry from the information of a file
+func NewEntry(fi os.FileInfo) Entry {
+	if fi == nil {
+		return Entry{}
+	}
+	return Entry{Exists: true, Size: fi.Size(), Mode: fi.Mode(), ModTime: fi.ModTime()}
+}
+
+//entryInfo makes an Entry look like the os.FileInfo it came from
+type entryInfo struct {
+	name string
+	e    Entry
+}
+
+func (ei *entryInfo) Name() string       { return ei.name }
+func (ei *entryInfo) Size() int64        { return ei.e.Size }
+func (ei *entryInfo) Mode() os.FileMode  { return ei.e.Mode }
+func (ei *entryInfo) ModTime() time.Time { return ei.e.ModTime }
+func (ei *entryInfo) IsDir() bool        { return ei.e.Mode.IsDir() }
+func (ei *entryInfo) Sys() interface{}   { return nil }
+
+//FileInfo returns the information of the entry for the file name
+func (e Entry) FileInfo(name string) os.FileInfo {
+	if !e.Exists {
+		return nil
+	}
+	return &entryInfo{filepath.Base(name), e}
+}
+
+//An Index is an on-disk cache of the list of snapshots of a dump and of
+//what each path looked up was in each snapshot. Snapshots are never modified once
+//written, so the dump only needs to be read for new snapshots and new paths.
+//An Index can be used concurrently.
+type Index struct {
+	file  string
+	mu    sync.Mutex
+	dirty bool
+
+	DumpRoot  string
+	Snapshots []string                    //relative to DumpRoot, sorted
+	Paths     map[string]map[string]Entry //path in the snapshot -> snapshot -> entry
+}
+
+//IndexPath is the file for the index of the dump, given by the environment variable
+//DUMPINDEX (so that it can be shared by the users of the dump) or in the user cache
+func IndexPath(roots Roots) (string, error) {
+	if p := os.Getenv(IndexVar); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	name := strings.Replace(strings.Trim(roots.DumpRoot, "/"), "/", "_", -1)
+	return filepath.Join(dir, "dump", name+".idx"), nil
+}
+
+//OpenIndex reads the index of the dump, which is empty if it does not exist yet.
+//The index is only a cache, if it cannot be decoded (it was written by an
+//older version) it is empty too and rebuilt.
+func OpenIndex(roots Roots) (ix *Index, err error) {
+	p, err := IndexPath(roots)
+	if err != nil {
+		return nil, err
+	}
+	ix = &Index{file: p, DumpRoot: roots.DumpRoot, Paths: make(map[string]map[string]Entry)}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(ix); err != nil {
+		Dprintf("index %s: %s, rebuilding it\n", p, err)
+		ix = &Index{file: p, DumpRoot: roots.DumpRoot, Paths: make(map[string]map[string]Entry)}
+		ix.dirty = true
+		return ix, nil
+	}
+	if ix.DumpRoot != roots.DumpRoot {
+		return nil, errors.New("index " + p + " is for dump " + ix.DumpRoot)
+	}
+	if ix.Paths == nil {
+		ix.Paths = make(map[string]map[string]Entry)
+	}
+	Dprintf("index %s: %d snapshots %d paths\n", p, len(ix.Snapshots), len(ix.Paths))
+	return ix, nil
+}
+
+//Refresh drops from the index the snapshots which are not in the dump any more,
+//removed by dprune(1) for example, and adds the ones which are newer than the last
+//one in it. Only the directories of the dump for the last year and day are listed.
+func (ix *Index) Refresh(roots Roots, nProcs int) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if err := ix.prune(roots, nProcs); err != nil {
+		return err
+	}
+	var from DumpDate
+	n := len(ix.Snapshots)
+	if n > 0 {
+		d, err := ParseDumpPath(roots.DumpRoot+"/"+ix.Snapshots[n-1], roots)
+		if err != nil {
+			return err
+		}
+		from = d
+	}
+	snaps, err := ListSnapshots(roots, from, nProcs)
+	if err != nil {
+		return err
+	}
+	for _, s := range snaps {
+		if n > 0 && !s.Date.IsAfter(from) {
+			continue
+		}
+		ix.Snapshots = append(ix.Snapshots, strings.TrimPrefix(s.Path, roots.DumpRoot+"/"))
+		ix.dirty = true
+	}
+	Dprintf("index refreshed: %d new snapshots\n", len(ix.Snapshots)-n)
+	return nil
+}
+
+//prune drops the snapshots which are gone from the dump, with their entries.
+//The snapshots in the index are only stated, up to nProcs at the same time.
+func (ix *Index) prune(roots Roots, nProcs int) error {
+	if nProcs < 1 {
+		nProcs = 1
+	}
+	gone := make([]bool, len(ix.Snapshots))
+	errs := make([]error, len(ix.Snapshots))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, nProcs)
+	for i, s := range ix.Snapshots {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, s string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, err := os.Stat(roots.DumpRoot + "/" + s)
+			if os.IsNotExist(err) {
+				gone[i] = true
+			} else {
+				errs[i] = err
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	var kept []string
+	for i, s := range ix.Snapshots {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if !gone[i] {
+			kept = append(kept, s)
+			continue
+		}
+		for _, snaps := range ix.Paths {
+			delete(snaps, s)
+		}
+		ix.dirty = true
+	}
+	Dprintf("index pruned: %d snapshots gone\n", len(ix.Snapshots)-len(kept))
+	ix.Snapshots = kept
+	return nil
+}
+
+//ListSnapshots is like the function of the same name, but the list comes from the index
+func (ix *Index) ListSnapshots(roots Roots, from DumpDate) (snaps []Snapshot, err error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, s := range ix.Snapshots {
+		p := roots.DumpRoot + "/" + s
+		d, err := ParseDumpPath(p, roots)
+		if err != nil {
+			return nil, err
+		}
+		if (&d).IsBefore(from) {
+			continue
+		}
+		snaps = append(snaps, Snapshot{p, d})
+	}
+	return snaps, nil
+}
+
+//splitPath separates a path in the dump into snapshot and path in the snapshot
+func (ix *Index) splitPath(path string) (snap string, rel string, err error) {
+	p := strings.TrimPrefix(path, ix.DumpRoot+"/")
+	if len(p) == len(path) {
+		return "", "", errors.New("not in the dump: " + path)
+	}
+	els := strings.SplitN(p, "/", snapshotDepth+1)
+	if len(els) != snapshotDepth+1 {
+		return "", "", errors.New("not in a snapshot: " + path)
+	}
+	return strings.Join(els[:snapshotDepth], "/"), "/" + els[snapshotDepth], nil
+}
+
+//Lookup finds what the path of the dump was when it was added to the index
+func (ix *Index) Lookup(path string) (e Entry, ok bool) {
+	snap, rel, err := ix.splitPath(path)
+	if err != nil {
+		return e, false
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	e, ok = ix.Paths[rel][snap]
+	return e, ok
+}
+
+//Add records what the path of the dump is
+func (ix *Index) Add(path string, e Entry) {
+	snap, rel, err := ix.splitPath(path)
+	if err != nil {
+		Dprintf("index: %s\n", err)
+		return
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	snaps := ix.Paths[rel]
+	if snaps == nil {
+		snaps = make(map[string]Entry)
+		ix.Paths[rel] = snaps
+	}
+	snaps[snap] = e
+	ix.dirty = true
+}
+
+//Save writes the index, if it changed, replacing the old one atomically
+func (ix *Index) Save() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if !ix.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(ix.file), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(ix.file), filepath.Base(ix.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(ix); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), ix.file); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	ix.dirty = false
+	return nil
+}
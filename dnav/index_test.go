@@ -0,0 +1,98 @@
+package dnav_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestIndex(t *testing.T) {
+	r, tmproot := mkTestDump(t, "index", []int{3, 7})
+	defer os.RemoveAll(tmproot)
+	os.Setenv(dnav.IndexVar, tmproot+"/idx")
+	defer os.Unsetenv(dnav.IndexVar)
+
+	ix, err := dnav.OpenIndex(r)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if err := ix.Refresh(r, 2); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	p := tmproot + "/2017/0503/1030/bin/file"
+	e := dnav.Entry{Exists: true, Size: 12, Mode: 0644, ModTime: time.Unix(1494425100, 0), Hashed: true}
+	e.Sum[0] = 42
+	ix.Add(p, e)
+	if err := ix.Save(); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+
+	os.MkdirAll(tmproot+"/2017/0708/0900/bin", 0700)
+	ix, err = dnav.OpenIndex(r)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if err := ix.Refresh(r, 2); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	snaps, err := ix.ListSnapshots(r, dnav.DumpDate{})
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if len(snaps) != 5 || snaps[4].Path != tmproot+"/2017/0708/0900" {
+		t.Fatalf("bad snapshots in index: %v", snaps)
+	}
+	e2, ok := ix.Lookup(p)
+	if !ok || e2.Size != e.Size || e2.Sum != e.Sum || !e2.ModTime.Equal(e.ModTime) {
+		t.Fatalf("bad entry %v for %s", e2, p)
+	}
+	if _, ok := ix.Lookup(tmproot + "/2017/0507/1030/bin/file"); ok {
+		t.Fatalf("should not be in the index")
+	}
+	if fi := e2.FileInfo(p); fi.Name() != "file" || fi.Size() != 12 || fi.IsDir() {
+		t.Fatalf("bad file info %v", fi)
+	}
+}
+
+func TestIndexPruned(t *testing.T) {
+	r, tmproot := mkTestDump(t, "indexpruned", []int{3, 7})
+	defer os.RemoveAll(tmproot)
+	os.Setenv(dnav.IndexVar, tmproot+"/idx")
+	defer os.Unsetenv(dnav.IndexVar)
+
+	ix, err := dnav.OpenIndex(r)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if err := ix.Refresh(r, 2); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	p := tmproot + "/2017/0503/1030/bin/file"
+	ix.Add(p, dnav.Entry{Exists: true, Size: 12, Mode: 0644})
+	if err := ix.Save(); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+
+	//prune the first and the last snapshots
+	os.RemoveAll(tmproot + "/2017/0503/1030")
+	os.RemoveAll(tmproot + "/2017/0507/2230")
+	ix, err = dnav.OpenIndex(r)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if err := ix.Refresh(r, 2); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	snaps, err := ix.ListSnapshots(r, dnav.DumpDate{})
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if len(snaps) != 2 || snaps[0].Path != tmproot+"/2017/0503/2230" || snaps[1].Path != tmproot+"/2017/0507/1030" {
+		t.Fatalf("bad snapshots in pruned index: %v", snaps)
+	}
+	if _, ok := ix.Lookup(p); ok {
+		t.Fatalf("pruned snapshot should not be in the index")
+	}
+}
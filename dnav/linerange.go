@@ -0,0 +1,128 @@
+package dnav
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//rangeEnd parses a number or a /regexp/ at the start of s, returning the rest
+func rangeEnd(s string) (n int, re *regexp.Regexp, rest string, err error) {
+	if !strings.HasPrefix(s, "/") {
+		i := strings.IndexByte(s, ',')
+		if i < 0 {
+			i = len(s)
+		}
+		n, err = strconv.Atoi(s[:i])
+		return n, nil, s[i:], err
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '/':
+			re, err = regexp.Compile(s[1:i])
+			return 0, re, s[i+1:], err
+		}
+	}
+	return 0, nil, "", errors.New("unterminated regexp in " + s)
+}
+
+//findLine returns the first line from i matching re, -1 if there is none
+func findLine(lines []string, i int, re *regexp.Regexp) int {
+	for ; i < len(lines); i++ {
+		if re.MatchString(lines[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+//ParseLineRange finds the lines of a text in a range given as start,end like git log -L, each
+//one a line number (from 1) or a /regexp/, the end searched from the line after the start.
+//The end may also be +n, for n lines. It returns the lines from 0, end not included.
+func ParseLineRange(s string, txt string) (start int, end int, err error) {
+	lines := splitLines(txt)
+	bad := errors.New("bad line range " + s)
+	n, re, rest, err := rangeEnd(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !strings.HasPrefix(rest, ",") {
+		return 0, 0, bad
+	}
+	start = n - 1
+	if re != nil {
+		if start = findLine(lines, 0, re); start < 0 {
+			return 0, 0, errors.New("no line matches " + re.String())
+		}
+	}
+	rest = rest[1:]
+	if strings.HasPrefix(rest, "+") {
+		n, err := strconv.Atoi(rest[1:])
+		if err != nil || n < 1 {
+			return 0, 0, bad
+		}
+		end = start + n
+	} else {
+		n, re, rest, err = rangeEnd(rest)
+		if err != nil || rest != "" {
+			return 0, 0, bad
+		}
+		end = n
+		if re != nil {
+			i := findLine(lines, start+1, re)
+			if i < 0 {
+				return 0, 0, errors.New("no line matches " + re.String())
+			}
+			end = i + 1
+		}
+	}
+	if start < 0 || end <= start || end > len(lines) {
+		return 0, 0, bad
+	}
+	return start, end, nil
+}
+
+//TrackRange maps a range of lines of txt2 to the lines of txt it comes from, txt being an
+//older version of it, comparing the lines as normalized by n. The lines deleted just
+//before the range are in it, the ones just after it are not.
+func TrackRange(txt string, txt2 string, start2 int, end2 int, n *Normalizer) (start int, end int) {
+	i, j := 0, 0
+	start, end = -1, -1
+	ops, _ := normOps(txt, txt2, n)
+	for _, op := range ops {
+		if start < 0 && j >= start2 {
+			start = i
+		}
+		if end < 0 && j >= end2 {
+			end = i
+		}
+		if op[0] != '+' {
+			i++
+		}
+		if op[0] != '-' {
+			j++
+		}
+	}
+	if start < 0 {
+		start = i
+	}
+	if end < 0 {
+		end = i
+	}
+	return start, end
+}
+
+//RangeHunks returns the hunks of the diff of a range of lines of two texts, as NormHunks
+//does, with the line numbers in the texts
+func RangeHunks(txt string, start int, end int, txt2 string, start2 int, end2 int, context int, n *Normalizer) (hunks []Hunk) {
+	lines, lines2 := splitLinesNL(txt), splitLinesNL(txt2)
+	hunks = NormHunks(strings.Join(lines[start:end], ""), strings.Join(lines2[start2:end2], ""), context, n)
+	for i := range hunks {
+		hunks[i].Start += start
+		hunks[i].Start2 += start2
+	}
+	return hunks
+}
@@ -0,0 +1,63 @@
+package dnav_test
+
+import (
+	"testing"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestParseLineRange(t *testing.T) {
+	txt := "package x\n\nfunc a() {\n\treturn\n}\n\nfunc b() {\n}\n"
+	for _, c := range []struct {
+		r          string
+		start, end int
+	}{
+		{"2,4", 1, 4},
+		{"/^func a/,/^}/", 2, 5},
+		{"/^func b/,/^}/", 6, 8},
+		{"/func a/,+2", 2, 4},
+		{"3,/^}/", 2, 5},
+	} {
+		start, end, err := dnav.ParseLineRange(c.r, txt)
+		if err != nil || start != c.start || end != c.end {
+			t.Fatalf("range %s should be %d,%d: %d,%d %v", c.r, c.start, c.end, start, end, err)
+		}
+	}
+	for _, r := range []string{"0,2", "4,2", "1,9", "/nothere/,2", "/func a,3", "2"} {
+		if _, _, err := dnav.ParseLineRange(r, txt); err == nil {
+			t.Fatalf("range %s should error", r)
+		}
+	}
+}
+
+func TestTrackRange(t *testing.T) {
+	old := "a\nb\nc\nd\ne\n"
+	new := "x\ny\na\nb\nC\nd\ne\n"
+	//c,d in the new text come from c,d in the old one
+	if start, end := dnav.TrackRange(old, new, 4, 6, nil); start != 2 || end != 4 {
+		t.Fatalf("bad range %d,%d", start, end)
+	}
+	//the lines inserted are not in the old text
+	if start, end := dnav.TrackRange(old, new, 0, 2, nil); start != 0 || end != 0 {
+		t.Fatalf("bad range of inserted lines %d,%d", start, end)
+	}
+	hunks := dnav.RangeHunks(old, 2, 4, new, 4, 6, 0, nil)
+	if len(hunks) != 1 || hunks[0].Start != 3 || hunks[0].Start2 != 5 || hunks[0].Lines[0] != "-c\n" {
+		t.Fatalf("bad hunks %v", hunks)
+	}
+	if hunks := dnav.RangeHunks(old, 3, 5, new, 5, 7, 0, nil); len(hunks) != 0 {
+		t.Fatalf("should be no hunks %v", hunks)
+	}
+
+	//reindenting the lines before the range does not move it
+	crlf := "a\r\n b\r\nc\r\nd\r\n"
+	spaced := "a\n  b\nc\nD\n"
+	n := &dnav.Normalizer{SpaceChange: true, LineEnds: true}
+	if start, end := dnav.TrackRange(crlf, spaced, 2, 4, n); start != 2 || end != 4 {
+		t.Fatalf("bad normalized range %d,%d", start, end)
+	}
+	hunks = dnav.RangeHunks(crlf, 2, 4, spaced, 2, 4, 0, n)
+	if len(hunks) != 1 || len(hunks[0].Lines) != 2 || hunks[0].Lines[0] != "-d\r\n" {
+		t.Fatalf("bad normalized hunks %v", hunks)
+	}
+}
@@ -0,0 +1,336 @@
+package dnav
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//ManifestFile is the name of the file in a snapshot directory (next to the
+//copy of the main root) with the manifest of the snapshot
+const ManifestFile = "manifest"
+
+//A ManifestEntry records a regular file of a snapshot. In the manifest each
+//is a line with the sha256, size, mode (octal) and path relative to the main root,
+//separated by spaces. The lines are sorted by path, so that it can be looked up.
+//The paths with a newline cannot be in it.
+type ManifestEntry struct {
+	Sum  [32]byte
+	Size int64
+	Mode os.FileMode
+	Rel  string
+}
+
+func (e *ManifestEntry) String() string {
+	return fmt.Sprintf("%x %d %o %s", e.Sum, e.Size, uint32(e.Mode), e.Rel)
+}
+
+func parseManifestEntry(line string) (e ManifestEntry, err error) {
+	els := strings.SplitN(line, " ", 4)
+	if len(els) != 4 {
+		return e, errors.New("bad manifest line: " + line)
+	}
+	sum, err := hex.DecodeString(els[0])
+	if err != nil || len(sum) != len(e.Sum) {
+		return e, errors.New("bad sum in manifest line: " + line)
+	}
+	copy(e.Sum[:], sum)
+	if e.Size, err = strconv.ParseInt(els[1], 10, 64); err != nil {
+		return e, errors.New("bad size in manifest line: " + line)
+	}
+	mode, err := strconv.ParseUint(els[2], 8, 32)
+	if err != nil {
+		return e, errors.New("bad mode in manifest line: " + line)
+	}
+	e.Mode = os.FileMode(mode)
+	e.Rel = els[3]
+	return e, nil
+}
+
+//ManifestPath is the path of the manifest of a snapshot
+func (s *Snapshot) ManifestPath() string {
+	return s.Path + "/" + ManifestFile
+}
+
+//ReadManifest reads all the manifest of a snapshot, sorted by path
+func ReadManifest(s *Snapshot) (m []ManifestEntry, err error) {
+	f, err := os.Open(s.ManifestPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		e, err := parseManifestEntry(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, e)
+	}
+	return m, scanner.Err()
+}
+
+//LookManifest finds the entry of a path in the manifest of a snapshot, doing
+//a binary search on the file, like look(1), instead of reading all of it
+func LookManifest(s *Snapshot, rel string) (e ManifestEntry, ok bool, err error) {
+	f, err := os.Open(s.ManifestPath())
+	if err != nil {
+		return e, false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return e, false, err
+	}
+	//lineAt reads the first line which starts after off
+	lineAt := func(off int64) (line string, err error) {
+		r := bufio.NewReader(io.NewSectionReader(f, off, fi.Size()-off))
+		if off > 0 {
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+		}
+		line, err = r.ReadString('\n')
+		return strings.TrimSuffix(line, "\n"), err
+	}
+	var ferr error
+	//the first offset from which the line read is not before rel
+	off := sort.Search(int(fi.Size()), func(i int) bool {
+		line, err := lineAt(int64(i))
+		if err == io.EOF && line == "" {
+			return true
+		}
+		e, err := parseManifestEntry(line)
+		if err != nil {
+			ferr = err
+			return true
+		}
+		return e.Rel >= rel
+	})
+	if ferr != nil {
+		return e, false, ferr
+	}
+	line, err := lineAt(int64(off))
+	if line == "" {
+		return e, false, nil
+	}
+	if e, err = parseManifestEntry(line); err != nil {
+		return e, false, err
+	}
+	return e, e.Rel == rel, nil
+}
+
+//manifestFiles lists the regular files of a snapshot. The ones with a newline in
+//their path cannot be in a manifest, they are skipped.
+func manifestFiles(s *Snapshot, roots Roots) (files map[string]os.FileInfo, skipped []string, err error) {
+	files = make(map[string]os.FileInfo)
+	err = WalkSnapshot(s, roots, "", nil, func(rel string, fi os.FileInfo) error {
+		switch {
+		case !fi.Mode().IsRegular():
+		case strings.ContainsRune(rel, '\n'):
+			skipped = append(skipped, rel)
+		default:
+			files[rel] = fi
+		}
+		return nil
+	})
+	sort.Strings(skipped)
+	return files, skipped, err
+}
+
+//WriteManifest hashes the regular files of a snapshot, up to nProcs at the same time, and
+//writes its manifest, replacing it atomically. The files which are hard links to the same
+//file in the previous snapshot (prev, which may be nil) with a manifest are not hashed again.
+//The files with a newline in their path are left out of it and returned in skipped.
+func WriteManifest(s *Snapshot, prev *Snapshot, roots Roots, nProcs int) (n int, skipped []string, err error) {
+	if nProcs < 1 {
+		nProcs = 1
+	}
+	files, skipped, err := manifestFiles(s, roots)
+	if err != nil {
+		return 0, nil, err
+	}
+	prevSums := make(map[string]ManifestEntry)
+	if prev != nil {
+		if pm, err := ReadManifest(prev); err == nil {
+			for _, e := range pm {
+				prevSums[e.Rel] = e
+			}
+		}
+	}
+	m := make([]ManifestEntry, 0, len(files))
+	for rel, fi := range files {
+		m = append(m, ManifestEntry{Size: fi.Size(), Mode: fi.Mode(), Rel: rel})
+	}
+	sort.Slice(m, func(i, j int) bool {
+		return m[i].Rel < m[j].Rel
+	})
+
+	var lk sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, nProcs)
+	for i := range m {
+		e := &m[i]
+		if pe, ok := prevSums[e.Rel]; ok {
+			pfi, err := os.Lstat(prev.PathOf(e.Rel, roots))
+			if err == nil && os.SameFile(pfi, files[e.Rel]) {
+				e.Sum = pe.Sum
+				continue
+			}
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			sum, herr := HashFile(s.PathOf(e.Rel, roots))
+			lk.Lock()
+			defer lk.Unlock()
+			if herr != nil && err == nil {
+				err = herr
+			}
+			e.Sum = sum
+		}()
+	}
+	wg.Wait()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	f, err := ioutil.TempFile(s.Path, ManifestFile+".tmp")
+	if err != nil {
+		return 0, nil, err
+	}
+	w := bufio.NewWriter(f)
+	for i := range m {
+		fmt.Fprintln(w, m[i].String())
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.ManifestPath())
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, nil, err
+	}
+	return len(m), skipped, nil
+}
+
+//Kinds of problems found verifying a snapshot
+const (
+	Corrupted  = "corrupted"  //the content, size or mode is not the one in the manifest
+	Missing    = "missing"    //in the manifest, but not in the snapshot
+	Unexpected = "unexpected" //in the snapshot, but not in the manifest
+)
+
+//A Problem is a file of a snapshot which does not match its manifest
+type Problem struct {
+	Kind string
+	Rel  string
+	Msg  string
+}
+
+//VerifySnapshot rehashes the files of a snapshot, up to nProcs at the same
+//time, and compares them to its manifest. The files which cannot be in it are not checked.
+func VerifySnapshot(s *Snapshot, roots Roots, nProcs int) (problems []Problem, err error) {
+	if nProcs < 1 {
+		nProcs = 1
+	}
+	m, err := ReadManifest(s)
+	if err != nil {
+		return nil, err
+	}
+	files, _, err := manifestFiles(s, roots)
+	if err != nil {
+		return nil, err
+	}
+	var lk sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, nProcs)
+	problem := func(kind string, rel string, msg string) {
+		lk.Lock()
+		defer lk.Unlock()
+		problems = append(problems, Problem{kind, rel, msg})
+	}
+	for i := range m {
+		e := &m[i]
+		fi, ok := files[e.Rel]
+		if !ok {
+			problem(Missing, e.Rel, "")
+			continue
+		}
+		delete(files, e.Rel)
+		switch {
+		case fi.Size() != e.Size:
+			problem(Corrupted, e.Rel, fmt.Sprintf("size %d, not %d", fi.Size(), e.Size))
+			continue
+		case fi.Mode() != e.Mode:
+			problem(Corrupted, e.Rel, fmt.Sprintf("mode %o, not %o", uint32(fi.Mode()), uint32(e.Mode)))
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			sum, err := HashFile(s.PathOf(e.Rel, roots))
+			switch {
+			case err != nil:
+				problem(Corrupted, e.Rel, err.Error())
+			case sum != e.Sum:
+				problem(Corrupted, e.Rel, fmt.Sprintf("sha256 %x, not %x", sum, e.Sum))
+			}
+		}()
+	}
+	wg.Wait()
+	for rel := range files {
+		problems = append(problems, Problem{Unexpected, rel, ""})
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].Rel < problems[j].Rel
+	})
+	return problems, nil
+}
+
+//ManifestSum returns the sum of a file of the dump from the manifest of its snapshot,
+//if there is one and the size and mode in it are the ones of fi
+func ManifestSum(path string, fi os.FileInfo, roots Roots) (sum [32]byte, ok bool) {
+	if fi == nil || !fi.Mode().IsRegular() || !IsDump(path, roots) {
+		return sum, false
+	}
+	rel, err := RelPath(path, roots)
+	if err != nil {
+		return sum, false
+	}
+	d, err := ParseDumpPath(path, roots)
+	if err != nil {
+		return sum, false
+	}
+	s := Snapshot{roots.DumpRoot + "/" + d.Name(), d}
+	if filepath.Clean(s.PathOf(rel, roots)) != filepath.Clean(path) {
+		return sum, false
+	}
+	e, ok, err := LookManifest(&s, rel)
+	if err != nil || !ok || e.Size != fi.Size() || e.Mode != fi.Mode() {
+		if err != nil && !os.IsNotExist(err) {
+			Dprintf("manifest %s: %s\n", s.ManifestPath(), err)
+		}
+		return sum, false
+	}
+	return e.Sum, true
+}
@@ -0,0 +1,99 @@
+package dnav_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestManifest(t *testing.T) {
+	var r dnav.Roots
+	tmproot := TmpDumpRootBase + "manifest"
+	os.RemoveAll(tmproot)
+	defer os.RemoveAll(tmproot)
+	live := tmproot + "/live"
+	os.MkdirAll(live+"/a/b", 0755)
+	os.MkdirAll(tmproot+"/dump", 0755)
+	var rels []string
+	for i := 0; i < 200; i++ {
+		rel := fmt.Sprintf("/a/f%d", i)
+		if i%3 == 0 {
+			rel = fmt.Sprintf("/a/b/g%d.txt", i)
+		}
+		os.WriteFile(live+rel, []byte(rel), 0644)
+		rels = append(rels, rel)
+	}
+	//a name which cannot be in the manifest
+	os.WriteFile(live+"/a/new\nline", []byte("x"), 0644)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmproot+"/dump")
+	dnav.RdRoots(&r)
+
+	t1 := time.Date(2017, 5, 3, 10, 30, 0, 0, time.Local)
+	s1, _, err := dnav.TakeSnapshot(r, t1, nil)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if n, skipped, err := dnav.WriteManifest(&s1, nil, r, 4); err != nil || n != len(rels) || len(skipped) != 1 || skipped[0] != "/a/new\nline" {
+		t.Fatalf("bad manifest, %d files, skipped %q: %s", n, skipped, err)
+	}
+	for _, rel := range rels {
+		e, ok, err := dnav.LookManifest(&s1, rel)
+		if err != nil || !ok || e.Sum != sha256.Sum256([]byte(rel)) || e.Size != int64(len(rel)) {
+			t.Fatalf("bad entry for %s: %v %v %s", rel, e, ok, err)
+		}
+	}
+	for _, rel := range []string{"", "/", "/a", "/a/b/g0", "/a/f100x", "/z"} {
+		if _, ok, err := dnav.LookManifest(&s1, rel); err != nil || ok {
+			t.Fatalf("should not find %s: %s", rel, err)
+		}
+	}
+	p := s1.PathOf("/a/f1", r)
+	fi, _ := os.Stat(p)
+	if sum, ok := dnav.ManifestSum(p, fi, r); !ok || sum != sha256.Sum256([]byte("/a/f1")) {
+		t.Fatalf("should find the sum of %s in the manifest", p)
+	}
+
+	t2 := t1.Add(24 * time.Hour)
+	s2, _, err := dnav.TakeSnapshot(r, t2, nil)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if _, _, err := dnav.WriteManifest(&s2, &s1, r, 4); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if problems, err := dnav.VerifySnapshot(&s2, r, 4); err != nil || len(problems) != 0 {
+		t.Fatalf("should verify %v %s", problems, err)
+	}
+
+	//bit rot in s1, which also shows in s2 through the hard link
+	os.WriteFile(s1.PathOf("/a/f1", r), []byte("/a/fX"), 0644)
+	os.Remove(s1.PathOf("/a/f2", r))
+	os.WriteFile(s1.PathOf("/a/new", r), []byte("new"), 0644)
+	os.Chmod(s1.PathOf("/a/f4", r), 0600)
+	problems, err := dnav.VerifySnapshot(&s1, r, 4)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	want := []dnav.Problem{
+		{Kind: dnav.Corrupted, Rel: "/a/f1"},
+		{Kind: dnav.Missing, Rel: "/a/f2"},
+		{Kind: dnav.Corrupted, Rel: "/a/f4"},
+		{Kind: dnav.Unexpected, Rel: "/a/new"},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("bad problems %v", problems)
+	}
+	for i, p := range problems {
+		if p.Kind != want[i].Kind || p.Rel != want[i].Rel {
+			t.Fatalf("bad problem %v, should be %v", p, want[i])
+		}
+	}
+	if _, err := dnav.VerifySnapshot(&dnav.Snapshot{Path: tmproot + "/dump/2017"}, r, 1); err == nil {
+		t.Fatalf("should error, no manifest")
+	}
+}
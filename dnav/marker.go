@@ -0,0 +1,92 @@
+package dnav
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Names of the markers directly inside the dump root
+const (
+	CurrentMarker    = "current"     //the newest complete snapshot
+	CurrentChkMarker = "current_chk" //the snapshot being written, while it is
+	FirstMarker      = "first"       //the oldest snapshot
+)
+
+//ReadMarker returns the path of the snapshot a marker of the dump points to.
+//A marker can be a symbolic link to the snapshot or a file with its path,
+//absolute or relative to the dump root. It returns "" if the marker does not exist.
+func ReadMarker(name string, roots Roots) (path string, err error) {
+	p := roots.DumpRoot + "/" + name
+	fi, err := os.Lstat(p)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		path, err = os.Readlink(p)
+	case fi.Mode().IsRegular():
+		var b []byte
+		b, err = ioutil.ReadFile(p)
+		path = strings.TrimSpace(string(b))
+	default:
+		return "", errors.New("bad marker " + p)
+	}
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return "", errors.New("empty marker " + p)
+	}
+	if !filepath.IsAbs(path) {
+		path = roots.DumpRoot + "/" + path
+	}
+	return filepath.Clean(path), nil
+}
+
+//WriteMarker makes a marker of the dump point to a snapshot, replacing it atomically.
+//The marker is a symbolic link relative to the dump root.
+func WriteMarker(name string, s *Snapshot, roots Roots) error {
+	p := roots.DumpRoot + "/" + name
+	tmp := p + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(s.Date.Name(), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+//ParseAge interprets an age or period given by the user as a number followed by
+//h (hours), d (days), w (weeks), m (months of 30 days) or y (years of 365 days)
+func ParseAge(s string) (age time.Duration, err error) {
+	units := map[byte]time.Duration{
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'm': 30 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if len(s) < 2 {
+		return 0, errors.New("bad age " + s)
+	}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, errors.New("bad unit in age " + s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, errors.New("bad age " + s)
+	}
+	return time.Duration(n) * unit, nil
+}
@@ -0,0 +1,57 @@
+package dnav_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestReadMarker(t *testing.T) {
+	r, tmproot := mkTestDump(t, "marker", []int{3})
+	defer os.RemoveAll(tmproot)
+
+	os.RemoveAll(tmproot + "/" + dnav.CurrentMarker)
+	os.RemoveAll(tmproot + "/" + dnav.FirstMarker)
+	os.Symlink("2017/0503/2230", tmproot+"/"+dnav.CurrentMarker)
+	os.WriteFile(tmproot+"/"+dnav.FirstMarker, []byte(tmproot+"/2017/0503/1030\n"), 0600)
+
+	markers := []struct {
+		name string
+		path string
+	}{
+		{dnav.CurrentMarker, tmproot + "/2017/0503/2230"},
+		{dnav.FirstMarker, tmproot + "/2017/0503/1030"},
+		{dnav.CurrentChkMarker, ""},
+	}
+	for _, m := range markers {
+		p, err := dnav.ReadMarker(m.name, r)
+		if err != nil || p != m.path {
+			t.Fatalf("bad marker %s: [%s] %s", m.name, p, err)
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	ages := []struct {
+		s   string
+		age time.Duration
+	}{
+		{"2h", 2 * time.Hour},
+		{"2d", 48 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"6m", 180 * 24 * time.Hour},
+		{"1y", 365 * 24 * time.Hour},
+	}
+	for _, a := range ages {
+		if age, err := dnav.ParseAge(a.s); err != nil || age != a.age {
+			t.Fatalf("bad age %s: %s %s", a.s, age, err)
+		}
+	}
+	for _, s := range []string{"", "h", "2", "2s", "-2d", "x2d"} {
+		if _, err := dnav.ParseAge(s); err == nil {
+			t.Fatalf("should error, bad age %s", s)
+		}
+	}
+}
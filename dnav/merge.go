@@ -0,0 +1,129 @@
+package dnav
+
+import (
+	"strings"
+)
+
+//Markers of the conflicts of Merge3, as in diff3 -m
+const (
+	MarkOurs   = "<<<<<<<"
+	MarkBase   = "|||||||"
+	MarkSep    = "======="
+	MarkTheirs = ">>>>>>>"
+)
+
+//A lineChange replaces the lines from start to end (not included)
+//of the base text with lines
+type lineChange struct {
+	start int
+	end   int
+	lines []string
+}
+
+//lineChanges returns the changes from the lines of base to txt
+func lineChanges(base string, txt string) (changes []lineChange) {
+	i := 0
+	var c *lineChange
+	for _, op := range lineOps(base, txt) {
+		if op[0] == ' ' {
+			c = nil
+			i++
+			continue
+		}
+		if c == nil {
+			changes = append(changes, lineChange{start: i, end: i})
+			c = &changes[len(changes)-1]
+		}
+		if op[0] == '-' {
+			i++
+			c.end = i
+		} else {
+			c.lines = append(c.lines, op[1:])
+		}
+	}
+	return changes
+}
+
+//applyChanges returns the lines from start to end of base with the changes,
+//which have to be in between
+func applyChanges(base []string, start int, end int, changes []lineChange) (lines []string) {
+	for _, c := range changes {
+		lines = append(lines, base[start:c.start]...)
+		lines = append(lines, c.lines...)
+		start = c.end
+	}
+	return append(lines, base[start:end]...)
+}
+
+//writeLines writes lines, ending the last one if it is not, for a marker to follow
+func writeLines(b *strings.Builder, lines []string) {
+	for _, l := range lines {
+		b.WriteString(l)
+	}
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		b.WriteString("\n")
+	}
+}
+
+//Merge3 merges the changes from base to theirs into ours line by line. The changes
+//which overlap or touch and are not the same are conflicts, with the three versions
+//between markers followed by the labels, as diff3 -m does. It returns the number of conflicts.
+func Merge3(ours string, base string, theirs string, labels [3]string) (merged string, nConflicts int) {
+	baseLines := splitLinesNL(base)
+	cs := [2][]lineChange{lineChanges(base, ours), lineChanges(base, theirs)}
+	var b strings.Builder
+	pos := 0
+	for len(cs[0]) > 0 || len(cs[1]) > 0 {
+		//a group of changes which touch each other, from the first one
+		first := 0
+		if len(cs[0]) == 0 || len(cs[1]) > 0 && cs[1][0].start < cs[0][0].start {
+			first = 1
+		}
+		start, end := cs[first][0].start, cs[first][0].end
+		var group [2][]lineChange
+		for grown := true; grown; {
+			grown = false
+			for side := 0; side < 2; side++ {
+				for len(cs[side]) > 0 && cs[side][0].start <= end {
+					c := cs[side][0]
+					group[side] = append(group[side], c)
+					cs[side] = cs[side][1:]
+					if c.end > end {
+						end = c.end
+					}
+					grown = true
+				}
+			}
+		}
+		for _, l := range baseLines[pos:start] {
+			b.WriteString(l)
+		}
+		pos = end
+		lines := applyChanges(baseLines, start, end, group[0])
+		lines2 := applyChanges(baseLines, start, end, group[1])
+		switch {
+		case len(group[1]) == 0:
+			lines2 = lines
+		case len(group[0]) == 0:
+			lines = lines2
+		}
+		if strings.Join(lines, "") == strings.Join(lines2, "") {
+			for _, l := range lines {
+				b.WriteString(l)
+			}
+			continue
+		}
+		nConflicts++
+		b.WriteString(MarkOurs + " " + labels[0] + "\n")
+		writeLines(&b, lines)
+		b.WriteString(MarkBase + " " + labels[1] + "\n")
+		writeLines(&b, baseLines[start:end])
+		b.WriteString(MarkSep + "\n")
+		writeLines(&b, lines2)
+		b.WriteString(MarkTheirs + " " + labels[2] + "\n")
+	}
+	for _, l := range baseLines[pos:] {
+		b.WriteString(l)
+	}
+	return b.String(), nConflicts
+}
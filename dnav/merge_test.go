@@ -0,0 +1,34 @@
+package dnav_test
+
+import (
+	"testing"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestMerge3(t *testing.T) {
+	labels := [3]string{"ours", "base", "theirs"}
+	base := "1\n2\n3\n4\n5\n6\n7\n"
+	ours := "1\n2\n3\n4\n5\nsix\n7\n8\n"
+	theirs := "one\n2\n3\n4\n5\n6\n7\n"
+	merged, n := dnav.Merge3(ours, base, theirs, labels)
+	if n != 0 || merged != "one\n2\n3\n4\n5\nsix\n7\n8\n" {
+		t.Fatalf("bad merge %d:\n%s", n, merged)
+	}
+	//the same change on both sides is not a conflict
+	if merged, n := dnav.Merge3(theirs, base, theirs, labels); n != 0 || merged != theirs {
+		t.Fatalf("bad merge of the same change %d:\n%s", n, merged)
+	}
+	if merged, n := dnav.Merge3(ours, base, base, labels); n != 0 || merged != ours {
+		t.Fatalf("bad merge of no change %d:\n%s", n, merged)
+	}
+	merged, n = dnav.Merge3("1\n2\nB\n4\n", "1\n2\n3\n4\n", "1\n2\nC\n4\n", labels)
+	want := "1\n2\n<<<<<<< ours\nB\n||||||| base\n3\n=======\nC\n>>>>>>> theirs\n4\n"
+	if n != 1 || merged != want {
+		t.Fatalf("bad conflict %d:\n%s\nshould be:\n%s", n, merged, want)
+	}
+	//a deleted file has no lines, they are all conflicts with ours
+	if _, n := dnav.Merge3("1\nx\n", "1\n", "", labels); n != 1 {
+		t.Fatalf("should be a conflict %d", n)
+	}
+}
@@ -0,0 +1,154 @@
+package dnav
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+//A Normalizer tells which differences between two texts do not matter when
+//they are compared line by line, as the options -b -w -B -I and
+//--strip-trailing-cr of diff(1). A nil Normalizer compares the lines as they are.
+type Normalizer struct {
+	SpaceChange bool           //ignore changes in the amount of white space
+	AllSpace    bool           //ignore all white space
+	BlankLines  bool           //ignore blank lines
+	LineEnds    bool           //ignore the carriage return at the end of the lines
+	Ignore      *regexp.Regexp //ignore the lines matching it
+}
+
+//Line returns the line as it is compared, without its end of line
+func (n *Normalizer) Line(l string) string {
+	l = strings.TrimSuffix(l, "\n")
+	if n == nil {
+		return l
+	}
+	if n.LineEnds {
+		l = strings.TrimSuffix(l, "\r")
+	}
+	switch {
+	case n.AllSpace:
+		l = strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, l)
+	case n.SpaceChange:
+		var b strings.Builder
+		inSpace := false
+		for _, r := range strings.TrimRightFunc(l, unicode.IsSpace) {
+			if unicode.IsSpace(r) {
+				if !inSpace {
+					b.WriteByte(' ')
+				}
+				inSpace = true
+				continue
+			}
+			inSpace = false
+			b.WriteRune(r)
+		}
+		l = b.String()
+	}
+	return l
+}
+
+//Ignores is true for the lines whose changes do not matter
+func (n *Normalizer) Ignores(l string) bool {
+	if n == nil {
+		return false
+	}
+	l = strings.TrimSuffix(l, "\n")
+	if n.BlankLines && strings.TrimSpace(l) == "" {
+		return true
+	}
+	return n.Ignore != nil && n.Ignore.MatchString(l)
+}
+
+//Text returns the text as it is compared, the lines which are not ignored normalized.
+//Two texts are the same version if their normalized texts are equal.
+func (n *Normalizer) Text(txt string) string {
+	var b strings.Builder
+	for _, l := range splitLinesNL(txt) {
+		if !n.Ignores(l) {
+			b.WriteString(n.Line(l))
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+//normOps is lineOps comparing the lines as normalized by n, the equal lines are the ones
+//of txt2. Ignored is true for the deleted and inserted lines which n ignores.
+func normOps(txt string, txt2 string, n *Normalizer) (ops []string, ignored []bool) {
+	ls, ls2 := splitLinesNL(txt), splitLinesNL(txt2)
+	keys := func(ls []string) (ks []string) {
+		for _, l := range ls {
+			ks = append(ks, n.Line(l))
+		}
+		return ks
+	}
+	a, b, _ := linesToRunes(keys(ls), keys(ls2))
+	i, j := 0, 0
+	for _, diff := range diffmatchpatch.New().DiffMainRunes(a, b, false) {
+		for range diff.Text {
+			switch diff.Type {
+			case diffmatchpatch.DiffDelete:
+				ops = append(ops, "-"+ls[i])
+				ignored = append(ignored, n.Ignores(ls[i]))
+				i++
+			case diffmatchpatch.DiffInsert:
+				ops = append(ops, "+"+ls2[j])
+				ignored = append(ignored, n.Ignores(ls2[j]))
+				j++
+			default:
+				ops = append(ops, " "+ls2[j])
+				ignored = append(ignored, false)
+				i++
+				j++
+			}
+		}
+	}
+	return ops, ignored
+}
+
+//NormHunks is Hunks comparing the lines as normalized by n. The
+//hunks which only change lines ignored by n are left out.
+func NormHunks(txt string, txt2 string, context int, n *Normalizer) (hunks []Hunk) {
+	ops, ignored := normOps(txt, txt2, n)
+	return opsHunks(ops, ignored, context)
+}
+
+//NormDiff returns the differences between two texts in the format used by hist(1),
+//comparing the lines as normalized by n
+func NormDiff(path string, txt string, path2 string, txt2 string, n *Normalizer) string {
+	var b strings.Builder
+	for _, h := range NormHunks(txt, txt2, 0, n) {
+		nl, nl2 := h.Start-1, h.Start2-1
+		var del, ins []string
+		for _, l := range h.Lines {
+			if l[0] == '-' {
+				del = append(del, strings.TrimSuffix(l[1:], "\n"))
+			} else {
+				ins = append(ins, strings.TrimSuffix(l[1:], "\n"))
+			}
+		}
+		if len(del) > 0 {
+			fmt.Fprintf(&b, "\n%s:%d,%d %s:%d,%d\n\n", path, nl+1, nl+len(del)+1, path2, nl2+1, nl2+1)
+			for _, l := range del {
+				b.WriteString("<" + l + "\n")
+			}
+			nl += len(del)
+		}
+		if len(ins) > 0 {
+			fmt.Fprintf(&b, "\n%s:%d,%d %s:%d,%d\n\n", path, nl+1, nl+1, path2, nl2+1, nl2+len(ins)+1)
+			for _, l := range ins {
+				b.WriteString(">" + l + "\n")
+			}
+		}
+	}
+	return b.String()
+}
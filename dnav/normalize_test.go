@@ -0,0 +1,59 @@
+package dnav_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestNormalizer(t *testing.T) {
+	txt := "func f() {\n\treturn  1\n}\n"
+	for _, c := range []struct {
+		n    *dnav.Normalizer
+		txt2 string
+		same bool
+	}{
+		{nil, txt, true},
+		{nil, "func f() {\n\treturn 1\n}\n", false},
+		{&dnav.Normalizer{SpaceChange: true}, "func f() {\n  return 1 \n}\n", true},
+		{&dnav.Normalizer{SpaceChange: true}, "func f() {\n\treturn1\n}\n", false},
+		{&dnav.Normalizer{AllSpace: true}, "func f(){\n\treturn1\n}\n", true},
+		{&dnav.Normalizer{BlankLines: true}, "func f() {\n\n\treturn  1\n}\n\n", true},
+		{&dnav.Normalizer{LineEnds: true}, "func f() {\r\n\treturn  1\r\n}\r\n", true},
+		{&dnav.Normalizer{LineEnds: true}, "func f() {\r\n\treturn 1\r\n}\r\n", false},
+		{&dnav.Normalizer{Ignore: regexp.MustCompile("^//")}, "//f returns 1\nfunc f() {\n\treturn  1\n}\n", true},
+	} {
+		if same := c.n.Text(txt) == c.n.Text(c.txt2); same != c.same {
+			t.Fatalf("%+v: %q same %v, should be %v", c.n, c.txt2, same, c.same)
+		}
+		if hunks := dnav.NormHunks(txt, c.txt2, 0, c.n); (len(hunks) == 0) != c.same {
+			t.Fatalf("%+v: %q bad hunks %v", c.n, c.txt2, hunks)
+		}
+	}
+}
+
+func TestNormDiff(t *testing.T) {
+	txt := "a\nb\n\nc\n"
+	txt2 := "a\nb \n\n\nC\n"
+	n := &dnav.Normalizer{SpaceChange: true, BlankLines: true}
+	d := dnav.NormDiff("f", txt, "f2", txt2, n)
+	if strings.Contains(d, ">b") || strings.Contains(d, "<b") {
+		t.Fatalf("space change in the diff: %s", d)
+	}
+	if !strings.Contains(d, "<c\n") || !strings.Contains(d, ">C\n") {
+		t.Fatalf("change missing in the diff: %s", d)
+	}
+	if d != dnav.NormDiff("f", txt, "f2", txt2, n) {
+		t.Fatal("diff not stable")
+	}
+	//many distinct lines, their codes include the one of a newline
+	var b strings.Builder
+	for i := 0; i < 100; i++ {
+		b.WriteString(strings.Repeat("x", i) + "\n")
+	}
+	if hunks := dnav.NormHunks(b.String(), b.String(), 0, n); len(hunks) != 0 {
+		t.Fatalf("hunks of the same text %v", hunks)
+	}
+}
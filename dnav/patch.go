@@ -0,0 +1,376 @@
+package dnav
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+//Lines of context around the changes in a unified diff
+const DiffContext = 3
+
+//A Hunk is a run of changed lines with their context, as in a unified diff.
+//The lines start with ' ', '-' or '+' and keep their end of line, the last
+//line of a text may not have one.
+type Hunk struct {
+	Start  int //first line in the old text, from 1
+	Len    int //number of lines in the old text
+	Start2 int //the same in the new text
+	Len2   int
+	Lines  []string
+}
+
+//splitLinesNL splits a text in lines keeping their end of line
+func splitLinesNL(txt string) (lines []string) {
+	for txt != "" {
+		i := strings.IndexByte(txt, '\n')
+		if i < 0 {
+			i = len(txt) - 1
+		}
+		lines = append(lines, txt[:i+1])
+		txt = txt[i+1:]
+	}
+	return lines
+}
+
+//lineOps compares two texts line by line, the lines returned start
+//with ' ', '-' or '+'
+func lineOps(txt string, txt2 string) (ops []string) {
+	dmp := diffmatchpatch.New()
+	a, b, lines := linesToRunes(splitLinesNL(txt), splitLinesNL(txt2))
+	for _, diff := range dmp.DiffMainRunes(a, b, false) {
+		op := " "
+		switch diff.Type {
+		case diffmatchpatch.DiffDelete:
+			op = "-"
+		case diffmatchpatch.DiffInsert:
+			op = "+"
+		}
+		for _, c := range diff.Text {
+			ops = append(ops, op+runeLine(lines, c))
+		}
+	}
+	return ops
+}
+
+//Hunks compares two texts line by line and returns the hunks of a unified diff
+//with context lines around the changes. Changes closer than twice the
+//context are in the same hunk.
+func Hunks(txt string, txt2 string, context int) (hunks []Hunk) {
+	return opsHunks(lineOps(txt, txt2), nil, context)
+}
+
+//opsHunks makes the hunks of the ops of lineOps, leaving out the changes which are ignored
+func opsHunks(ops []string, ignored []bool, context int) (hunks []Hunk) {
+	keep := make([]bool, len(ops))
+	for i, op := range ops {
+		if op[0] == ' ' || ignored != nil && ignored[i] {
+			continue
+		}
+		for j := i - context; j <= i+context; j++ {
+			if j >= 0 && j < len(ops) {
+				keep[j] = true
+			}
+		}
+	}
+	n, n2 := 1, 1
+	var h *Hunk
+	for i, op := range ops {
+		if !keep[i] {
+			h = nil
+		} else {
+			if h == nil {
+				hunks = append(hunks, Hunk{Start: n, Start2: n2})
+				h = &hunks[len(hunks)-1]
+			}
+			h.Lines = append(h.Lines, op)
+			if op[0] != '+' {
+				h.Len++
+			}
+			if op[0] != '-' {
+				h.Len2++
+			}
+		}
+		if op[0] != '+' {
+			n++
+		}
+		if op[0] != '-' {
+			n2++
+		}
+	}
+	return hunks
+}
+
+//String formats the hunk as in a unified diff
+func (h *Hunk) String() string {
+	var b strings.Builder
+	start, start2 := h.Start, h.Start2
+	if h.Len == 0 {
+		start--
+	}
+	if h.Len2 == 0 {
+		start2--
+	}
+	fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", start, h.Len, start2, h.Len2)
+	for _, l := range h.Lines {
+		b.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	return b.String()
+}
+
+//FmtUnified formats hunks as a unified diff from path to path2
+func FmtUnified(path string, path2 string, hunks []Hunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", path, path2)
+	for i := range hunks {
+		b.WriteString(hunks[i].String())
+	}
+	return b.String()
+}
+
+//Unified returns the unified diff of two texts
+func Unified(path string, txt string, path2 string, txt2 string) string {
+	return FmtUnified(path, path2, Hunks(txt, txt2, DiffContext))
+}
+
+//DiffStat counts the lines added and removed by the hunks
+func DiffStat(hunks []Hunk) (added int, removed int) {
+	for _, h := range hunks {
+		for _, l := range h.Lines {
+			switch l[0] {
+			case '+':
+				added++
+			case '-':
+				removed++
+			}
+		}
+	}
+	return added, removed
+}
+
+//A Change is a version of a file with the version before it,
+//Prev is nil if the file is created
+type Change struct {
+	Prev *Version
+	Version
+}
+
+//Changes returns the changes of a file in its history which are worth a patch:
+//creations, deletions, writes and changes of mode. The versions in which the path
+//is a directory are skipped.
+func Changes(versions []Version) (changes []Change) {
+	var last *Version
+	for i := range versions {
+		v := &versions[i]
+		if v.Info != nil && v.Info.IsDir() {
+			last = nil
+			continue
+		}
+		switch {
+		case v.Kind == Delete && last == nil:
+			continue
+		case v.Kind == Wstat && last != nil && v.Info.Mode() == last.Info.Mode():
+			last = v //only the modification time changed
+			continue
+		case v.Kind == Create:
+			last = nil
+		}
+		changes = append(changes, Change{last, *v})
+		last = v
+		if v.Kind == Delete {
+			last = nil
+		}
+	}
+	return changes
+}
+
+//readText reads a file to diff it, ok is false for binary files and files bigger than maxSize
+func readText(path string, size int64, maxSize int64) (txt string, ok bool, err error) {
+	if size > maxSize {
+		return "", false, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	txt = string(b)
+	if !utf8.ValidString(txt) || strings.IndexByte(txt, 0) >= 0 {
+		return "", false, nil
+	}
+	return txt, true, nil
+}
+
+//Subject describes a change of the file rel in a line, with its size and mode
+func (c *Change) Subject(rel string, roots Roots) string {
+	s := roots.RootName + rel + ": "
+	switch {
+	case c.Info == nil:
+		return s + fmt.Sprintf("delete, %d bytes", c.Prev.Info.Size())
+	case c.Prev == nil:
+		return s + fmt.Sprintf("create, %d bytes, mode %#o", c.Info.Size(), c.Info.Mode().Perm())
+	}
+	s += fmt.Sprintf("%d -> %d bytes", c.Prev.Info.Size(), c.Info.Size())
+	if c.Prev.Info.Mode() != c.Info.Mode() {
+		s += fmt.Sprintf(", mode %#o -> %#o", c.Prev.Info.Mode().Perm(), c.Info.Mode().Perm())
+	}
+	return s
+}
+
+//Diff returns the change as a git diff of the file rel, files bigger than the
+//MaxSize of cmp or binary are only said to differ. The lines are compared
+//normalized by the Norm of cmp.
+func (c *Change) Diff(rel string, cmp *Comparer) (diff string, hunks []Hunk, err error) {
+	name := strings.TrimPrefix(rel, "/")
+	a, b := "a/"+name, "b/"+name
+	var txt, txt2 string
+	isText := true
+	var ok bool
+	var hdr strings.Builder
+	fmt.Fprintf(&hdr, "diff --git %s %s\n", a, b)
+	switch {
+	case c.Prev == nil:
+		fmt.Fprintf(&hdr, "new file mode %s\n", gitMode(c.Info))
+		a = "/dev/null"
+	case c.Info == nil:
+		fmt.Fprintf(&hdr, "deleted file mode %s\n", gitMode(c.Prev.Info))
+		b = "/dev/null"
+	case gitMode(c.Prev.Info) != gitMode(c.Info):
+		fmt.Fprintf(&hdr, "old mode %s\nnew mode %s\n", gitMode(c.Prev.Info), gitMode(c.Info))
+	}
+	if c.Prev != nil {
+		if txt, ok, err = readText(c.Prev.Path, c.Prev.Info.Size(), cmp.MaxSize); err != nil {
+			return "", nil, err
+		}
+		isText = isText && ok
+	}
+	if c.Info != nil {
+		if txt2, ok, err = readText(c.Path, c.Info.Size(), cmp.MaxSize); err != nil {
+			return "", nil, err
+		}
+		isText = isText && ok
+	}
+	if !isText {
+		fmt.Fprintf(&hdr, "Binary files %s and %s differ\n", a, b)
+		return hdr.String(), nil, nil
+	}
+	hunks = NormHunks(txt, txt2, DiffContext, cmp.Norm)
+	return hdr.String() + FmtUnified(a, b, hunks), hunks, nil
+}
+
+//WritePatch writes the change of the file rel to w as a mail, as git format-patch does,
+//numbered n of total and dated at the snapshot of the change. Mails written one
+//after the other make an mbox, which git am can apply in a copy of the main root.
+func (c *Change) WritePatch(w io.Writer, rel string, n int, total int, cmp *Comparer) error {
+	roots := cmp.Roots
+	diff, hunks, err := c.Diff(rel, cmp)
+	if err != nil {
+		return err
+	}
+	t := c.Snapshot.Date.Time()
+	added, removed := DiffStat(hunks)
+	_, err = fmt.Fprintf(w, "From %s %s\nFrom: %s\nDate: %s\nSubject: [PATCH %d/%d] %s\n\n"+
+		"%s of %s in the dump %s\n---\n %d insertions(+), %d deletions(-)\n\n%s-- \ndump\n\n",
+		strings.Fields(GitCommitter)[0], t.Format("Mon Jan _2 15:04:05 2006"), GitCommitter,
+		t.Format("Mon, 2 Jan 2006 15:04:05 -0700"), n, total, c.Subject(rel, roots),
+		c.Kind, roots.RootName+rel, c.Snapshot.Date.Name(), added, removed, diff)
+	return err
+}
+
+//Reverse returns the hunk which undoes h, its deleted lines
+//before its inserted ones in each run of changes
+func (h *Hunk) Reverse() (r Hunk) {
+	r = Hunk{Start: h.Start2, Len: h.Len2, Start2: h.Start, Len2: h.Len}
+	var ins []string
+	for _, l := range h.Lines {
+		switch l[0] {
+		case '+':
+			r.Lines = append(r.Lines, "-"+l[1:])
+		case '-':
+			ins = append(ins, "+"+l[1:])
+		default:
+			r.Lines = append(r.Lines, ins...)
+			r.Lines = append(r.Lines, l)
+			ins = nil
+		}
+	}
+	r.Lines = append(r.Lines, ins...)
+	return r
+}
+
+//hunkSides returns the lines of the old and new text in the hunk
+func hunkSides(h *Hunk) (old []string, new []string) {
+	for _, l := range h.Lines {
+		if l[0] != '+' {
+			old = append(old, l[1:])
+		}
+		if l[0] != '-' {
+			new = append(new, l[1:])
+		}
+	}
+	return old, new
+}
+
+//matchAt finds if the lines are in txt at i
+func matchAt(txt []string, i int, lines []string) bool {
+	if i < 0 || i+len(lines) > len(txt) {
+		return false
+	}
+	for j, l := range lines {
+		if txt[i+j] != l {
+			return false
+		}
+	}
+	return true
+}
+
+//ApplyHunks applies the hunks to a text, as patch does. A hunk is applied where its
+//old lines are, the closest to where they were in the old text, shifted as much as
+//the hunk before it was. The hunks which cannot be applied are returned, the text
+//has the others.
+func ApplyHunks(txt string, hunks []Hunk) (patched string, rejected []Hunk) {
+	lines := splitLinesNL(txt)
+	var b strings.Builder
+	pos, offset := 0, 0
+	for i := range hunks {
+		h := &hunks[i]
+		old, new := hunkSides(h)
+		want := h.Start - 1 + offset
+		at := -1
+		for d := 0; want-d >= pos || want+d <= len(lines)-len(old); d++ {
+			if want-d >= pos && matchAt(lines, want-d, old) {
+				at = want - d
+				break
+			}
+			if want+d >= pos && matchAt(lines, want+d, old) {
+				at = want + d
+				break
+			}
+		}
+		if at < 0 {
+			rejected = append(rejected, *h)
+			continue
+		}
+		for _, l := range lines[pos:at] {
+			b.WriteString(l)
+		}
+		for _, l := range new {
+			b.WriteString(l)
+		}
+		pos = at + len(old)
+		offset = at - (h.Start - 1)
+	}
+	for _, l := range lines[pos:] {
+		b.WriteString(l)
+	}
+	return b.String(), rejected
+}
@@ -0,0 +1,151 @@
+package dnav_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestUnified(t *testing.T) {
+	txt := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	txt2 := "1\ntwo\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13"
+	hunks := dnav.Hunks(txt, txt2, 3)
+	if len(hunks) != 2 {
+		t.Fatalf("should be two hunks %v", hunks)
+	}
+	want := "--- a\n+++ b\n@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+		"@@ -10,3 +10,4 @@\n 10\n 11\n 12\n+13\n\\ No newline at end of file\n"
+	if d := dnav.Unified("a", txt, "b", txt2); d != want {
+		t.Fatalf("bad diff:\n%s\nshould be:\n%s", d, want)
+	}
+	if hunks := dnav.Hunks(txt, txt2, 5); len(hunks) != 1 {
+		t.Fatalf("close changes should be in a hunk %v", hunks)
+	}
+	if d := dnav.Unified("a", txt, "b", txt); d != "" {
+		t.Fatalf("should be no diff: %s", d)
+	}
+	if d := dnav.Unified("a", "", "b", "x\n"); d != "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+x\n" {
+		t.Fatalf("bad diff of a created file: %s", d)
+	}
+	if added, removed := dnav.DiffStat(hunks); added != 2 || removed != 1 {
+		t.Fatalf("bad stat %d %d", added, removed)
+	}
+}
+
+func TestWritePatch(t *testing.T) {
+	var r dnav.Roots
+	tmproot := TmpDumpRootBase + "patch"
+	os.RemoveAll(tmproot)
+	defer os.RemoveAll(tmproot)
+	live := tmproot + "/live"
+	os.MkdirAll(live, 0755)
+	os.MkdirAll(tmproot+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmproot+"/dump")
+	dnav.RdRoots(&r)
+
+	f := live + "/f.txt"
+	changes := []func(){
+		func() { os.WriteFile(f, []byte("one\n"), 0644) },
+		func() { os.WriteFile(f, []byte("one\ntwo\n"), 0644) },
+		func() { os.Chtimes(f, time.Now(), time.Now().Add(time.Hour)) },
+		func() { os.Chmod(f, 0755) },
+		func() { os.Remove(f) },
+	}
+	t1 := time.Date(2017, 5, 3, 10, 30, 0, 0, time.Local)
+	for i, change := range changes {
+		change()
+		if _, _, err := dnav.TakeSnapshot(r, t1.Add(time.Duration(i)*time.Hour), nil); err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+	}
+	snaps, err := dnav.ListSnapshots(r, dnav.DumpDate{}, 1)
+	if err != nil {
+		t.Fatalf("should list the snapshots %s", err)
+	}
+	versions, err := dnav.History(snaps, r, "/f.txt", 2)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	cs := dnav.Changes(versions)
+	if len(cs) != 4 || cs[0].Prev != nil || cs[2].Kind != dnav.Wstat || cs[3].Kind != dnav.Delete {
+		t.Fatalf("bad changes %v", cs)
+	}
+	var b bytes.Buffer
+	for i := range cs {
+		if err := cs[i].WritePatch(&b, "/f.txt", i+1, len(cs), &dnav.Comparer{Roots: r, MaxSize: 1024}); err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+	}
+	out := b.String()
+	want := []string{
+		"Date: Wed, 3 May 2017 10:30:00",
+		"Subject: [PATCH 1/4] live/f.txt: create, 4 bytes, mode 0644\n",
+		"new file mode 100644\n--- /dev/null\n+++ b/f.txt\n@@ -0,0 +1,1 @@\n+one\n",
+		"Subject: [PATCH 2/4] live/f.txt: 4 -> 8 bytes\n",
+		"--- a/f.txt\n+++ b/f.txt\n@@ -1,1 +1,2 @@\n one\n+two\n",
+		"Subject: [PATCH 3/4] live/f.txt: 8 -> 8 bytes, mode 0644 -> 0755\n",
+		"old mode 100644\nnew mode 100755\n-- \n",
+		"Subject: [PATCH 4/4] live/f.txt: delete, 8 bytes\n",
+		"deleted file mode 100755\n--- a/f.txt\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-one\n-two\n",
+	}
+	for _, w := range want {
+		i := strings.Index(out, w)
+		if i < 0 {
+			t.Fatalf("patches should have %q, they are\n%s", w, out)
+		}
+		out = out[i+len(w):]
+	}
+}
+
+func TestApplyHunks(t *testing.T) {
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	new := "1\ntwo\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"
+	hunks := dnav.Hunks(old, new, 3)
+	//the live file has lines added at the start and a change in the middle
+	live := "0\n0\n" + "1\n2\n3\n4\n5\nsix\n7\n8\n9\n10\n11\n12\n"
+	patched, rej := dnav.ApplyHunks(live, hunks)
+	if len(rej) != 0 || patched != "0\n0\n1\ntwo\n3\n4\n5\nsix\n7\n8\n9\n10\n11\n12\n13\n" {
+		t.Fatalf("bad patch %v:\n%s", rej, patched)
+	}
+	//reverting the change
+	var rev []dnav.Hunk
+	for i := range hunks {
+		rev = append(rev, hunks[i].Reverse())
+	}
+	if rev[0].Lines[1] != "-two\n" || rev[0].Lines[2] != "+2\n" {
+		t.Fatalf("bad reverse %v", rev[0].Lines)
+	}
+	reverted, rej := dnav.ApplyHunks(patched, rev)
+	if len(rej) != 0 || reverted != live {
+		t.Fatalf("bad revert %v:\n%s", rej, reverted)
+	}
+	//the context of the first hunk is gone
+	reverted, rej = dnav.ApplyHunks(strings.Replace(patched, "3\n", "three\n", 1), rev)
+	if len(rej) != 1 || rej[0].Start != 1 || !strings.HasSuffix(reverted, "12\n") {
+		t.Fatalf("should reject the first hunk %v:\n%s", rej, reverted)
+	}
+}
+
+func TestReverseAtEnd(t *testing.T) {
+	h := dnav.Hunk{Start: 1, Len: 3, Start2: 1, Len2: 1, Lines: []string{" a\n", "-b\n", "-c\n"}}
+	r := h.Reverse()
+	if len(r.Lines) != 3 || r.Lines[1] != "+b\n" || r.Lines[2] != "+c\n" || r.Len != 1 || r.Len2 != 3 {
+		t.Fatalf("bad reverse %+v", r)
+	}
+	//reverting the deletion of the lines at the end of a text
+	old := "1\n2\n3\n4\n5\n"
+	new := "1\n2\n3\n"
+	var rev []dnav.Hunk
+	for _, h := range dnav.Hunks(old, new, 3) {
+		rev = append(rev, h.Reverse())
+	}
+	reverted, rej := dnav.ApplyHunks(new, rev)
+	if len(rej) != 0 || reverted != old {
+		t.Fatalf("bad revert %v:\n%s", rej, reverted)
+	}
+}
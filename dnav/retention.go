@@ -0,0 +1,139 @@
+package dnav
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//A Period is how often snapshots are kept by a Rule
+type Period int
+
+const (
+	All Period = iota
+	Hourly
+	Daily
+	Weekly
+	Monthly
+	Yearly
+)
+
+var periodNames = []string{"all", "hourly", "daily", "weekly", "monthly", "yearly"}
+
+func (p Period) String() string {
+	return periodNames[p]
+}
+
+//key is the same for the dates in the same period
+func (p Period) key(t time.Time) string {
+	switch p {
+	case Hourly:
+		return t.Format("2006010215")
+	case Daily:
+		return t.Format("20060102")
+	case Weekly:
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", y, w)
+	case Monthly:
+		return t.Format("200601")
+	case Yearly:
+		return t.Format("2006")
+	}
+	return t.Format("200601021504")
+}
+
+//A Rule keeps one snapshot per Period (all of them for All) among the snapshots
+//younger than Age, or of any age if Age is zero
+type Rule struct {
+	Period Period
+	Age    time.Duration
+}
+
+//A Policy is a list of rules for increasing ages. Each snapshot is kept
+//or not by the first rule for its age, it is not kept if there is none.
+type Policy []Rule
+
+//ParsePolicy interprets a policy written as rules separated by commas, each
+//a period and an age as in ParseAge, like all:2d,hourly:2w,daily:6m,monthly.
+//The age of the last rule may be missing, meaning forever.
+func ParsePolicy(s string) (p Policy, err error) {
+	for i, r := range strings.Split(s, ",") {
+		var rule Rule
+		els := strings.SplitN(r, ":", 2)
+		found := false
+		for pd, name := range periodNames {
+			if els[0] == name {
+				rule.Period = Period(pd)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("bad period in rule " + r)
+		}
+		if len(els) == 1 || els[1] == "forever" {
+			if i != len(strings.Split(s, ","))-1 {
+				return nil, errors.New("only the last rule can be forever: " + r)
+			}
+		} else if rule.Age, err = ParseAge(els[1]); err != nil || rule.Age == 0 {
+			return nil, errors.New("bad age in rule " + r)
+		}
+		if i > 0 && rule.Age != 0 && rule.Age <= p[i-1].Age {
+			return nil, errors.New("ages of the rules should increase: " + r)
+		}
+		p = append(p, rule)
+	}
+	return p, nil
+}
+
+func (p Policy) String() string {
+	var rs []string
+	for _, r := range p {
+		if r.Age == 0 {
+			rs = append(rs, r.Period.String())
+		} else {
+			rs = append(rs, fmt.Sprintf("%s:%s", r.Period, FmtAge(r.Age)))
+		}
+	}
+	return strings.Join(rs, ",")
+}
+
+//rule finds the rule which applies to a snapshot of the given age, -1 if none
+func (p Policy) rule(age time.Duration) int {
+	for i, r := range p {
+		if r.Age == 0 || age <= r.Age {
+			return i
+		}
+	}
+	return -1
+}
+
+//Plan decides which of the snapshots (sorted by date) the policy keeps. Of all
+//the snapshots in a period, the oldest is kept, as hist(1) does when filtering.
+//The newest snapshot and the ones for which pinned (which may be nil) returns true are
+//always kept.
+func (p Policy) Plan(snaps []Snapshot, now time.Time, pinned func(s *Snapshot) bool) (keep []bool) {
+	keep = make([]bool, len(snaps))
+	seen := make(map[string]bool)
+	for i := range snaps {
+		s := &snaps[i]
+		t := s.Date.Time()
+		if i == len(snaps)-1 || (pinned != nil && pinned(s)) {
+			keep[i] = true
+		}
+		ri := p.rule(now.Sub(t))
+		if ri < 0 {
+			continue
+		}
+		if p[ri].Period == All {
+			keep[i] = true
+			continue
+		}
+		k := fmt.Sprintf("%d %s", ri, p[ri].Period.key(t))
+		if !seen[k] {
+			seen[k] = true
+			keep[i] = true
+		}
+	}
+	return keep
+}
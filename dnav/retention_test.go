@@ -0,0 +1,82 @@
+package dnav_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := dnav.ParsePolicy("all:2d,hourly:2w,daily:6m,monthly")
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if len(p) != 4 || p[0].Period != dnav.All || p[1].Age != 14*24*time.Hour || p[3].Period != dnav.Monthly || p[3].Age != 0 {
+		t.Fatalf("bad policy %v", p)
+	}
+	if p.String() != "all:2d,hourly:14d,daily:180d,monthly" {
+		t.Fatalf("bad policy string %s", p)
+	}
+	for _, s := range []string{"", "sometimes:2d", "all:2d,daily,monthly", "daily:2w,hourly:2d", "daily:0d"} {
+		if _, err := dnav.ParsePolicy(s); err == nil {
+			t.Fatalf("should error, bad policy %s", s)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.Local)
+	var snaps []dnav.Snapshot
+	//every 6 hours for 90 days
+	for h := 90 * 24; h >= 0; h -= 6 {
+		tm := now.Add(-time.Duration(h) * time.Hour)
+		snaps = append(snaps, dnav.Snapshot{Path: tm.Format("2006/0102/1504"), Date: dnav.TInDumpDate(tm)})
+	}
+	p, err := dnav.ParsePolicy("all:2d,daily:2w,monthly:60d")
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	first := &snaps[0]
+	keep := p.Plan(snaps, now, func(s *dnav.Snapshot) bool { return s == first })
+	nAll, nDaily, nOld := 0, 0, 0
+	for i, s := range snaps {
+		age := now.Sub(s.Date.Time())
+		if !keep[i] {
+			continue
+		}
+		switch {
+		case age <= 48*time.Hour:
+			nAll++
+		case age <= 14*24*time.Hour:
+			nDaily++
+		case age > 60*24*time.Hour:
+			nOld++
+		}
+	}
+	if nAll != 9 {
+		t.Fatalf("should keep all 9 of the last 2 days, kept %d", nAll)
+	}
+	if nDaily < 12 || nDaily > 13 {
+		t.Fatalf("should keep one per day for 12 days, kept %d", nDaily)
+	}
+	if nOld != 1 || !keep[0] || !keep[len(keep)-1] {
+		t.Fatalf("should keep only the pinned of the oldest, kept %d", nOld)
+	}
+
+	//the plan should not change when run again on what it kept,
+	//for the snapshots which stay under the same rule
+	var kept []dnav.Snapshot
+	for i := range snaps {
+		if keep[i] {
+			kept = append(kept, snaps[i])
+		}
+	}
+	keep2 := p.Plan(kept, now.Add(time.Hour), nil)
+	for i := range kept {
+		age := now.Sub(kept[i].Date.Time())
+		if !keep2[i] && age > 49*time.Hour && age < 13*24*time.Hour {
+			t.Fatalf("should keep %s again", kept[i].Path)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package dnav
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//A Snapshot is a directory of the dump of the form DumpRoot/yyyy/mmdd/hhmm
+//which contains a copy of the main root
+type Snapshot struct {
+	Path string
+	Date DumpDate
+}
+
+const snapshotDepth = 3 //years, days, hours
+
+//ListSnapshots walks the dump and returns the snapshots not before from,
+//sorted by date. Up to nProcs directories are read at the same time.
+func ListSnapshots(roots Roots, from DumpDate, nProcs int) ([]Snapshot, error) {
+	if nProcs < 1 {
+		nProcs = 1
+	}
+	sem := make(chan struct{}, nProcs)
+	snaps, err := listLevel(&roots, roots.DumpRoot, 1, from, sem)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(snaps, func(i, j int) bool {
+		return snaps[i].Date.IsBefore(snaps[j].Date)
+	})
+	return snaps, nil
+}
+
+//numericNames returns the numeric names in a directory
+//not bigger than max (if max >= 0), biggest first
+func numericNames(dir string, max int) (names []int, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		n, err := strconv.Atoi(f.Name())
+		if err != nil || !f.IsDir() || (max >= 0 && n > max) {
+			continue
+		}
+		names = append(names, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(names)))
+	return names, nil
+}
+
+//FindSnapshot finds the newest snapshot which is not after the date.
+//Unlike FindDumpPath, if there is no snapshot for the day (or year)
+//of the date, it looks in the previous ones.
+func FindSnapshot(d DumpDate, roots Roots) (s Snapshot, err error) {
+	years, err := numericNames(roots.DumpRoot, d.years)
+	if err != nil {
+		return s, err
+	}
+	for _, y := range years {
+		yPath := fmt.Sprintf("%s/%4.4d", roots.DumpRoot, y)
+		maxDay := -1
+		if y == d.years {
+			maxDay = 100*d.months + d.days
+		}
+		days, err := numericNames(yPath, maxDay)
+		if err != nil {
+			return s, err
+		}
+		for _, day := range days {
+			dPath := fmt.Sprintf("%s/%4.4d", yPath, day)
+			maxHour := -1
+			if y == d.years && day == maxDay {
+				maxHour = d.hours
+			}
+			hours, err := numericNames(dPath, maxHour)
+			if err != nil {
+				return s, err
+			}
+			if len(hours) > 0 {
+				s.Path = fmt.Sprintf("%s/%4.4d", dPath, hours[0])
+				s.Date = DumpDate{y, day / 100, day % 100, hours[0]}
+				return s, nil
+			}
+		}
+	}
+	return s, errors.New("no dump before " + d.Name())
+}
+
+//truncate leaves only the part of the date which is
+//in the path of a directory of the given level of the dump
+func (d DumpDate) truncate(level int) DumpDate {
+	switch level {
+	case 1:
+		return DumpDate{years: d.years}
+	case 2:
+		return DumpDate{years: d.years, months: d.months, days: d.days}
+	}
+	return d
+}
+
+//listLevel reads the numeric directories of one level of the dump
+//and descends concurrently into the ones which are not before from.
+//An error reading any of them is returned, not to miss snapshots.
+func listLevel(roots *Roots, dir string, level int, from DumpDate, sem chan struct{}) (snaps []Snapshot, err error) {
+	sem <- struct{}{}
+	files, err := ioutil.ReadDir(dir)
+	<-sem
+	if err != nil {
+		return nil, err
+	}
+	var subs []Snapshot
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		if _, err := strconv.Atoi(f.Name()); err != nil {
+			continue
+		}
+		p := dir + "/" + f.Name()
+		d, err := ParseDumpPath(p, *roots)
+		if err != nil {
+			continue
+		}
+		if (&d).IsBefore(from.truncate(level)) {
+			continue
+		}
+		subs = append(subs, Snapshot{p, d})
+	}
+	if level == snapshotDepth {
+		return subs, nil
+	}
+
+	found := make([][]Snapshot, len(subs))
+	errs := make([]error, len(subs))
+	var wg sync.WaitGroup
+	for i := range subs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			found[i], errs[i] = listLevel(roots, subs[i].Path, level+1, from, sem)
+		}(i)
+	}
+	wg.Wait()
+	for i, s := range found {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		snaps = append(snaps, s...)
+	}
+	return snaps, nil
+}
+
+//FmtAge formats the age of a snapshot with days and no seconds, like 3d4h5m.
+//The units which are zero are left out, 2h and not 2h0m.
+func FmtAge(age time.Duration) string {
+	age = age.Round(time.Minute)
+	days := age / (24 * time.Hour)
+	hours := age % (24 * time.Hour) / time.Hour
+	mins := age % time.Hour / time.Minute
+	s := ""
+	if days > 0 {
+		s += fmt.Sprintf("%dd", days)
+	}
+	if hours > 0 {
+		s += fmt.Sprintf("%dh", hours)
+	}
+	if mins > 0 || s == "" {
+		s += fmt.Sprintf("%dm", mins)
+	}
+	return s
+}
@@ -0,0 +1,126 @@
+package dnav_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+//mkTestDump creates a dump with a snapshot per day of days at 10:30 and 22:30
+//of may 2017 and sets the roots to it
+func mkTestDump(t *testing.T, name string, days []int) (r dnav.Roots, tmproot string) {
+	tmproot = TmpDumpRootBase + name
+	os.RemoveAll(tmproot)
+	os.Setenv(dnav.MainRootVar, "/adfadf:/2rsdfewr2/asf3qer:/bin")
+	if err := os.Mkdir(tmproot, 0700); err != nil {
+		t.Fatalf("cannot create dump %s", err)
+	}
+	os.Setenv(dnav.MainDumpVar, "/adf13123adf:/sdfsd/asf3qer:"+tmproot)
+	dnav.RdRoots(&r)
+	for _, d := range days {
+		for _, h := range []int{1030, 2230} {
+			p := fmt.Sprintf("%s/2017/05%2.2d/%4.4d/bin", tmproot, d, h)
+			os.MkdirAll(p, 0700)
+		}
+	}
+	for _, f := range []string{"current", "first", "lost+found", "2017/notanumber"} {
+		os.MkdirAll(tmproot+"/"+f, 0700)
+	}
+	return r, tmproot
+}
+
+func TestListSnapshots(t *testing.T) {
+	r, tmproot := mkTestDump(t, "listsnapshots", []int{12, 3, 7, 20})
+	defer os.RemoveAll(tmproot)
+
+	for _, nProcs := range []int{1, 4} {
+		snaps, err := dnav.ListSnapshots(r, dnav.DumpDate{}, nProcs)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		if len(snaps) != 8 {
+			t.Fatalf("should be 8 snapshots, there are %d: %v", len(snaps), snaps)
+		}
+		for i := 1; i < len(snaps); i++ {
+			if !snaps[i-1].Date.IsBefore(snaps[i].Date) {
+				t.Fatalf("snapshots not sorted %s %s", snaps[i-1].Path, snaps[i].Path)
+			}
+		}
+		if snaps[0].Path != tmproot+"/2017/0503/1030" {
+			t.Fatalf("bad first snapshot %s", snaps[0].Path)
+		}
+	}
+
+	from := *dnav.NewDumpDate(2017, 5, 7, 2230)
+	snaps, err := dnav.ListSnapshots(r, from, 2)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if len(snaps) != 5 || snaps[0].Path != tmproot+"/2017/0507/2230" {
+		t.Fatalf("bad snapshots from %s: %v", &from, snaps)
+	}
+}
+
+func TestFindSnapshot(t *testing.T) {
+	r, tmproot := mkTestDump(t, "findsnapshot", []int{3, 7})
+	defer os.RemoveAll(tmproot)
+
+	dates := []struct {
+		d    *dnav.DumpDate
+		name string
+	}{
+		{dnav.NewDumpDate(2017, 5, 7, 2230), "2017/0507/2230"},
+		{dnav.NewDumpDate(2017, 5, 7, 2229), "2017/0507/1030"},
+		{dnav.NewDumpDate(2017, 5, 7, 900), "2017/0503/2230"},
+		{dnav.NewDumpDate(2017, 5, 5, 0), "2017/0503/2230"},
+		{dnav.NewDumpDate(2019, 1, 1, 0), "2017/0507/2230"},
+	}
+	for _, d := range dates {
+		s, err := dnav.FindSnapshot(*d.d, r)
+		if err != nil {
+			t.Fatalf("should not error for %s: %s", d.d, err)
+		}
+		if s.Path != tmproot+"/"+d.name || s.Date.Name() != d.name {
+			t.Fatalf("bad snapshot for %s: %s %s", d.d, s.Path, &s.Date)
+		}
+	}
+	if s, err := dnav.FindSnapshot(*dnav.NewDumpDate(2017, 5, 3, 1000), r); err == nil {
+		t.Fatalf("should error, no snapshot before: %s", s.Path)
+	}
+}
+
+func TestFmtAge(t *testing.T) {
+	ages := []struct {
+		age time.Duration
+		s   string
+	}{
+		{0, "0m"},
+		{90 * time.Second, "2m"},
+		{3*time.Hour + 4*time.Minute, "3h4m"},
+		{2 * time.Hour, "2h"},
+		{50 * time.Hour, "2d2h"},
+		{48 * time.Hour, "2d"},
+		{48*time.Hour + 5*time.Minute, "2d5m"},
+	}
+	for _, a := range ages {
+		if s := dnav.FmtAge(a.age); s != a.s {
+			t.Fatalf("bad age for %s: %s should be %s", a.age, s, a.s)
+		}
+	}
+}
+
+func TestListSnapshotsUnreadable(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("root reads unreadable directories")
+	}
+	r, tmproot := mkTestDump(t, "listunreadable", []int{3, 7})
+	defer os.RemoveAll(tmproot)
+	os.Chmod(tmproot+"/2017/0507", 0)
+	defer os.Chmod(tmproot+"/2017/0507", 0700)
+	if _, err := dnav.ListSnapshots(r, dnav.DumpDate{}, 2); err == nil {
+		t.Fatal("an unreadable day should error")
+	}
+}
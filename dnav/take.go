@@ -0,0 +1,243 @@
+package dnav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//TakingDir is the directory in the dump root where the snapshots are written,
+//they are renamed into their place in the dump when they are complete
+const TakingDir = "taking"
+
+//ErrorsFile is the name of the file in a snapshot directory (next to the
+//copy of the main root) with the errors found while taking it
+const ErrorsFile = "errors"
+
+//TakeStats counts what TakeSnapshot did
+type TakeStats struct {
+	Files  int      //regular files in the snapshot
+	Linked int      //files hard linked to the previous snapshot
+	Copied int64    //bytes copied
+	Errors []string //files which could not be copied
+}
+
+//PrevSnapshot returns the snapshot a new one for the date is linked against, the one current
+//points to or, if there is no such marker, the newest before the date. It is nil if there is none.
+func PrevSnapshot(d DumpDate, roots Roots) (prev *Snapshot, err error) {
+	p, err := ReadMarker(CurrentMarker, roots)
+	if err != nil {
+		return nil, err
+	}
+	if p != "" {
+		pd, err := ParseDumpPath(p, roots)
+		if err == nil && pd.IsBefore(d) {
+			return &Snapshot{p, pd}, nil
+		}
+	}
+	snaps, err := ListSnapshots(roots, DumpDate{}, 1)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(snaps) - 1; i >= 0; i-- {
+		if snaps[i].Date.IsBefore(d) {
+			return &snaps[i], nil
+		}
+	}
+	return nil, nil
+}
+
+//unchanged finds if a file of the main root can be linked to the one in
+//the previous snapshot, because it has the same size, time, mode and owner
+func unchanged(fi os.FileInfo, prevPath string) bool {
+	pfi, err := os.Lstat(prevPath)
+	if err != nil || !pfi.Mode().IsRegular() {
+		return false
+	}
+	if pfi.Size() != fi.Size() || !pfi.ModTime().Equal(fi.ModTime()) || pfi.Mode() != fi.Mode() {
+		return false
+	}
+	uid, gid, ok := FileOwner(fi)
+	puid, pgid, pok := FileOwner(pfi)
+	return ok == pok && uid == puid && gid == pgid
+}
+
+//copyFile copies a regular file keeping its mode and modification time
+func copyFile(dst string, src string, fi os.FileInfo) (n int64, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm()|0200)
+	if err != nil {
+		return 0, err
+	}
+	if n, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return n, err
+	}
+	if err = out.Close(); err != nil {
+		return n, err
+	}
+	if err = os.Chmod(dst, fi.Mode()); err != nil {
+		return n, err
+	}
+	return n, os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+//setOwner gives a copied file the owner of the original, only when running as root
+func setOwner(dst string, fi os.FileInfo) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	if uid, gid, ok := FileOwner(fi); ok {
+		return os.Lchown(dst, uid, gid)
+	}
+	return nil
+}
+
+//writeErrors writes the errors file of a snapshot
+func writeErrors(path string, errs []string) error {
+	return ioutil.WriteFile(path+"/"+ErrorsFile, []byte(strings.Join(errs, "\n")+"\n"), 0644)
+}
+
+//TakeSnapshot copies the main root into a new snapshot of the dump for the time t.
+//Regular files unchanged since the previous snapshot are hard links to it. While the
+//snapshot is being written in TakingDir, current_chk points to where it goes, and when it
+//is done it is renamed into place and current is made to point to it atomically (and first,
+//if it did not exist), so that the dump never has a half written snapshot. The directories for which
+//prune returns true (prune may be nil) are not copied, nor is the dump if it is
+//inside the main root. Files which cannot be copied are counted in the stats and
+//written to the errors file of the snapshot, they do not stop it.
+func TakeSnapshot(roots Roots, t time.Time, prune PruneFunc) (s Snapshot, stats TakeStats, err error) {
+	s.Date = TInDumpDate(t)
+	s.Path = roots.DumpRoot + "/" + s.Date.Name()
+	if _, err := os.Lstat(s.Path); err == nil {
+		return s, stats, errors.New("snapshot already exists: " + s.Path)
+	}
+	prev, err := PrevSnapshot(s.Date, roots)
+	if err != nil {
+		return s, stats, err
+	}
+	if prev != nil {
+		Dprintf("linking against %s\n", prev.Path)
+	}
+	//the snapshot is written with a name which is not a date, not to be listed
+	tmp := Snapshot{roots.DumpRoot + "/" + TakingDir + "/" + strings.Replace(s.Date.Name(), "/", "-", -1), s.Date}
+	if _, err := os.Lstat(tmp.Path); err == nil {
+		return s, stats, errors.New("unfinished snapshot, remove it: " + tmp.Path)
+	}
+	if err := os.MkdirAll(tmp.Path, 0755); err != nil {
+		return s, stats, err
+	}
+	if err := WriteMarker(CurrentChkMarker, &s, roots); err != nil {
+		return s, stats, err
+	}
+
+	type dirTime struct {
+		path string
+		fi   os.FileInfo
+	}
+	var dirs []dirTime
+	fail := func(rel string, err error) {
+		Dprintf("%s: %s\n", rel, err)
+		stats.Errors = append(stats.Errors, fmt.Sprintf("%s: %s", LivePath(rel, roots), err))
+	}
+	dumpRoot := filepath.Clean(roots.DumpRoot)
+	skip := len(roots.MainRoot)
+	err = filepath.Walk(roots.MainRoot, func(path string, fi os.FileInfo, err error) error {
+		rel := path[skip:]
+		if err != nil {
+			fail(rel, err)
+			return nil
+		}
+		dst := tmp.PathOf(rel, roots)
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			if rel != "" && (path == dumpRoot || (prune != nil && prune(rel, fi))) {
+				Dprintf("pruning %s\n", path)
+				return filepath.SkipDir
+			}
+			if err := os.Mkdir(dst, 0700); err != nil {
+				fail(rel, err)
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, dirTime{dst, fi})
+		case mode.IsRegular():
+			stats.Files++
+			if prev != nil && unchanged(fi, prev.PathOf(rel, roots)) {
+				if err := os.Link(prev.PathOf(rel, roots), dst); err == nil {
+					stats.Linked++
+					return nil
+				}
+			}
+			n, err := copyFile(dst, path, fi)
+			stats.Copied += n
+			if err == nil {
+				err = setOwner(dst, fi)
+			}
+			if err != nil {
+				fail(rel, err)
+			}
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err == nil {
+				err = os.Symlink(target, dst)
+			}
+			if err == nil {
+				err = setOwner(dst, fi)
+			}
+			if err != nil {
+				fail(rel, err)
+			}
+		default:
+			fail(rel, errors.New("not copied, special file "+mode.String()))
+		}
+		return nil
+	})
+	if err != nil {
+		return s, stats, err
+	}
+	//the contents are written, now the directories can get their mode and times
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		err := os.Chmod(d.path, d.fi.Mode().Perm())
+		if err == nil {
+			err = setOwner(d.path, d.fi)
+		}
+		if err == nil {
+			err = os.Chtimes(d.path, d.fi.ModTime(), d.fi.ModTime())
+		}
+		if err != nil {
+			fail(strings.TrimPrefix(d.path, tmp.PathOf("", roots)), err)
+		}
+	}
+	if len(stats.Errors) > 0 {
+		if err := writeErrors(tmp.Path, stats.Errors); err != nil {
+			return s, stats, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return s, stats, err
+	}
+	if err := os.Rename(tmp.Path, s.Path); err != nil {
+		return s, stats, err
+	}
+	if err := WriteMarker(CurrentMarker, &s, roots); err != nil {
+		return s, stats, err
+	}
+	if err := os.Remove(roots.DumpRoot + "/" + CurrentChkMarker); err != nil {
+		return s, stats, err
+	}
+	first, err := ReadMarker(FirstMarker, roots)
+	if err == nil && first == "" {
+		err = WriteMarker(FirstMarker, &s, roots)
+	}
+	return s, stats, err
+}
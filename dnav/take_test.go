@@ -0,0 +1,102 @@
+package dnav_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestTakeSnapshot(t *testing.T) {
+	var r dnav.Roots
+	tmproot := TmpDumpRootBase + "take"
+	os.RemoveAll(tmproot)
+	defer os.RemoveAll(tmproot)
+	live := tmproot + "/live"
+	//the dump inside the main root should not be copied
+	for _, d := range []string{live + "/a/node_modules", live + "/dump"} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("cannot create %s: %s", d, err)
+		}
+	}
+	os.WriteFile(live+"/a/f.txt", []byte("first\n"), 0644)
+	os.WriteFile(live+"/a/g.txt", []byte("same\n"), 0600)
+	os.WriteFile(live+"/a/node_modules/x", []byte("x\n"), 0644)
+	os.Symlink("f.txt", live+"/a/l")
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, live+"/dump")
+	dnav.RdRoots(&r)
+
+	t1 := time.Date(2017, 5, 3, 10, 30, 0, 0, time.Local)
+	s1, stats, err := dnav.TakeSnapshot(r, t1, dnav.Prune([]string{"node_modules"}))
+	if err != nil || len(stats.Errors) != 0 {
+		t.Fatalf("should not error: %s %v", err, stats.Errors)
+	}
+	if s1.Path != live+"/dump/2017/0503/1030" || stats.Files != 2 || stats.Linked != 0 {
+		t.Fatalf("bad first snapshot %s %+v", s1.Path, stats)
+	}
+	if _, _, err := dnav.TakeSnapshot(r, t1, nil); err == nil {
+		t.Fatalf("should error, snapshot exists")
+	}
+
+	os.WriteFile(live+"/a/f.txt", []byte("second\n"), 0644)
+	t2 := t1.Add(24 * time.Hour)
+	//the snapshot being taken is not in the dump yet
+	nListed := -1
+	prune := func(rel string, fi os.FileInfo) bool {
+		if snaps, err := dnav.ListSnapshots(r, dnav.DumpDate{}, 1); err == nil && nListed < 0 {
+			nListed = len(snaps)
+		}
+		return dnav.Prune([]string{"node_modules"})(rel, fi)
+	}
+	s2, stats, err := dnav.TakeSnapshot(r, t2, prune)
+	if err != nil || len(stats.Errors) != 0 {
+		t.Fatalf("should not error: %s %v", err, stats.Errors)
+	}
+	if stats.Files != 2 || stats.Linked != 1 || stats.Copied != int64(len("second\n")) {
+		t.Fatalf("bad second snapshot %+v", stats)
+	}
+	if nListed != 1 {
+		t.Fatalf("should not list the snapshot while it is taken, listed %d", nListed)
+	}
+	if p := dnav.FindDumpPath(dnav.TInDumpDate(t2), r); p != s2.Path {
+		t.Fatalf("should find the snapshot taken, found %s", p)
+	}
+	b, err := os.ReadFile(s2.PathOf("/a/f.txt", r))
+	if err != nil || string(b) != "second\n" {
+		t.Fatalf("bad copy %s %s", b, err)
+	}
+	fi, err := os.Stat(s1.PathOf("/a/g.txt", r))
+	fi2, err2 := os.Stat(s2.PathOf("/a/g.txt", r))
+	if err != nil || err2 != nil || !os.SameFile(fi, fi2) || fi2.Mode().Perm() != 0600 {
+		t.Fatalf("unchanged file should be linked %s %s", err, err2)
+	}
+	if target, err := os.Readlink(s2.PathOf("/a/l", r)); err != nil || target != "f.txt" {
+		t.Fatalf("bad symlink %s %s", target, err)
+	}
+	for _, p := range []string{"/a/node_modules", "/dump"} {
+		if _, err := os.Lstat(s2.PathOf(p, r)); !os.IsNotExist(err) {
+			t.Fatalf("%s should not be copied", p)
+		}
+	}
+
+	markers := []struct {
+		name string
+		path string
+	}{
+		{dnav.CurrentMarker, s2.Path},
+		{dnav.FirstMarker, s1.Path},
+		{dnav.CurrentChkMarker, ""},
+	}
+	for _, m := range markers {
+		p, err := dnav.ReadMarker(m.name, r)
+		if err != nil || p != m.path {
+			t.Fatalf("bad marker %s: [%s] %s", m.name, p, err)
+		}
+	}
+	snaps, err := dnav.ListSnapshots(r, dnav.DumpDate{}, 1)
+	if err != nil || len(snaps) != 2 {
+		t.Fatalf("should list the snapshots taken %v %s", snaps, err)
+	}
+}
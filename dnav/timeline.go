@@ -0,0 +1,162 @@
+package dnav
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+//A TimelineRow is a regular file in a snapshot, with the lines added
+//and removed since its previous version (-1 if they are not known,
+//for binary files or files too big to diff)
+type TimelineRow struct {
+	Snapshot Snapshot
+	Rel      string
+	Path     string
+	Info     os.FileInfo
+	Sum      [32]byte
+	Hashed   bool //Sum is known, files are not hashed when the Comparer has NoHash
+	Added    int
+	Removed  int
+}
+
+//Timeline returns a row for each regular file under rel (a file or a directory) in each of the
+//snapshots, ordered by snapshot and path. Up to nProcs snapshots are walked and files are hashed at
+//the same time, hard links to the previous version are not hashed again. Files bigger than
+//DefMaxSize are not diffed.
+func Timeline(snaps []Snapshot, roots Roots, rel string, nProcs int) (rows []TimelineRow, err error) {
+	return NewComparer(roots).Timeline(snaps, rel, nProcs)
+}
+
+//Timeline is Timeline comparing the files with c, a file is unchanged when it is the
+//same version for c and files bigger than its MaxSize are not diffed
+func (c *Comparer) Timeline(snaps []Snapshot, rel string, nProcs int) (rows []TimelineRow, err error) {
+	roots := c.Roots
+	if nProcs < 1 {
+		nProcs = 1
+	}
+	byDir := make([][]TimelineRow, len(snaps))
+	err = WalkSnapshots(snaps, roots, rel, nil, nProcs, func(i int, r string, fi os.FileInfo) error {
+		if fi.Mode().IsRegular() {
+			byDir[i] = append(byDir[i], TimelineRow{Snapshot: snaps[i], Rel: r, Path: snaps[i].PathOf(r, roots), Info: fi})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range byDir {
+		rows = append(rows, byDir[i]...)
+	}
+
+	//the previous version of each row, -1 for the first
+	prev := make([]int, len(rows))
+	files := make([]*File, len(rows))
+	last := make(map[string]int)
+	var toHash []*File
+	for i := range rows {
+		r := &rows[i]
+		p, ok := last[r.Rel]
+		if !ok {
+			p = -1
+		}
+		prev[i] = p
+		last[r.Rel] = i
+		files[i] = c.newFile(r.Path, r.Info)
+		if p >= 0 && os.SameFile(rows[p].Info, r.Info) {
+			continue
+		}
+		if !c.NoHash {
+			toHash = append(toHash, files[i])
+		}
+	}
+	var lk sync.Mutex
+	parDo(len(toHash), nProcs, func(i int) {
+		herr := toHash[i].Hash()
+		lk.Lock()
+		defer lk.Unlock()
+		if herr != nil && err == nil {
+			err = herr
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		r, f := &rows[i], files[i]
+		p := prev[i]
+		if p >= 0 && os.SameFile(rows[p].Info, r.Info) {
+			f.Sum, f.hashed = files[p].Sum, files[p].hashed
+		}
+		r.Sum, r.Hashed = f.Sum, f.hashed
+		if p >= 0 {
+			same, err := files[p].SameVersion(f)
+			files[p].Unload()
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				continue
+			}
+		}
+		r.Added, r.Removed = -1, -1
+		txt, ok, err := readText(r.Path, r.Info.Size(), c.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if p < 0 {
+			r.Added, r.Removed = len(splitLinesNL(txt)), 0
+			continue
+		}
+		ptxt, ok, err := readText(rows[p].Path, rows[p].Info.Size(), c.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			r.Added, r.Removed = DiffStat(NormHunks(ptxt, txt, 0, c.Norm))
+		}
+	}
+	return rows, nil
+}
+
+//Layout of the dates in the CSV of a timeline, which spreadsheets understand
+const csvDateLayout = "2006-01-02 15:04:05"
+
+//WriteTimelineCSV writes the rows of a timeline as CSV, with a header
+func WriteTimelineCSV(w io.Writer, rows []TimelineRow, roots Roots) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"snapshot", "path", "size", "mode", "mtime", "sha256", "added", "removed"})
+	lines := func(n int) string {
+		if n < 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	sum := func(r TimelineRow) string {
+		if !r.Hashed {
+			return ""
+		}
+		return fmt.Sprintf("%x", r.Sum)
+	}
+	for _, r := range rows {
+		cw.Write([]string{
+			r.Snapshot.Date.Time().Format(csvDateLayout),
+			roots.RootName + r.Rel,
+			strconv.FormatInt(r.Info.Size(), 10),
+			fmt.Sprintf("%#o", r.Info.Mode().Perm()),
+			r.Info.ModTime().Format(csvDateLayout),
+			sum(r),
+			lines(r.Added),
+			lines(r.Removed),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,78 @@
+package dnav_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestTimeline(t *testing.T) {
+	var r dnav.Roots
+	tmproot := TmpDumpRootBase + "timeline"
+	os.RemoveAll(tmproot)
+	defer os.RemoveAll(tmproot)
+	live := tmproot + "/live"
+	os.MkdirAll(live+"/d", 0755)
+	os.MkdirAll(tmproot+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmproot+"/dump")
+	dnav.RdRoots(&r)
+
+	changes := []func(){
+		func() {
+			os.WriteFile(live+"/d/f.txt", []byte("one\ntwo\n"), 0644)
+			os.WriteFile(live+"/d/b", []byte{0, 1, 2}, 0644)
+		},
+		func() {},
+		func() {
+			os.WriteFile(live+"/d/f.txt", []byte("one\n2\nthree\n"), 0600)
+			os.Chmod(live+"/d/f.txt", 0600)
+		},
+	}
+	t1 := time.Date(2017, 5, 3, 10, 30, 0, 0, time.Local)
+	for i, change := range changes {
+		change()
+		if _, _, err := dnav.TakeSnapshot(r, t1.Add(time.Duration(i)*time.Hour), nil); err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+	}
+	snaps, err := dnav.ListSnapshots(r, dnav.DumpDate{}, 1)
+	if err != nil {
+		t.Fatalf("should list the snapshots %s", err)
+	}
+	rows, err := dnav.NewComparer(r).Timeline(snaps, "/d", 2)
+	if err != nil || len(rows) != 6 {
+		t.Fatalf("should have a row per file and snapshot: %d %s", len(rows), err)
+	}
+	var b bytes.Buffer
+	if err := dnav.WriteTimelineCSV(&b, rows, r); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	recs, err := csv.NewReader(&b).ReadAll()
+	if err != nil || len(recs) != 7 {
+		t.Fatalf("bad csv %v %s", recs, err)
+	}
+	want := [][]string{
+		{"snapshot", "path", "size", "mode", "mtime", "sha256", "added", "removed"},
+		{"2017-05-03 10:30:00", "live/d/b", "3", "0644"},
+		{"2017-05-03 10:30:00", "live/d/f.txt", "8", "0644", "", "", "2", "0"},
+		{"2017-05-03 11:30:00", "live/d/b", "3", "0644", "", "", "", ""},
+		{"2017-05-03 11:30:00", "live/d/f.txt", "8", "0644", "", "", "0", "0"},
+		{"2017-05-03 12:30:00", "live/d/b", "3"},
+		{"2017-05-03 12:30:00", "live/d/f.txt", "12", "0600", "", "", "2", "1"},
+	}
+	for i, w := range want {
+		for j := range w {
+			if w[j] != "" && recs[i][j] != w[j] {
+				t.Fatalf("bad field %d of row %d: %v, should be %v", j, i, recs[i], w)
+			}
+		}
+	}
+	if recs[1][6] != "" || recs[2][5] != recs[4][5] || recs[4][5] == recs[6][5] {
+		t.Fatalf("bad rows %v", recs)
+	}
+}
@@ -0,0 +1,163 @@
+package dnav
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//RelPath returns the path relative to the main root of a path of the main root
+//or of a snapshot of the dump, "" for the root itself
+func RelPath(path string, roots Roots) (rel string, err error) {
+	path = filepath.Clean(path)
+	if IsDump(path, roots) {
+		els := strings.SplitN(strings.TrimPrefix(path, roots.DumpRoot), "/", snapshotDepth+3)
+		if len(els) < snapshotDepth+2 || els[snapshotDepth+1] != roots.RootName {
+			return "", errors.New("not in a snapshot: " + path)
+		}
+		if len(els) == snapshotDepth+2 {
+			return "", nil
+		}
+		return "/" + els[snapshotDepth+2], nil
+	}
+	if path == roots.MainRoot {
+		return "", nil
+	}
+	if !strings.HasPrefix(path, roots.MainRoot+"/") {
+		return "", errors.New("not in the main root: " + path)
+	}
+	return strings.TrimPrefix(path, roots.MainRoot), nil
+}
+
+//LivePath is the path in the main root for a path relative to it
+func LivePath(rel string, roots Roots) string {
+	return roots.MainRoot + rel
+}
+
+//TopDir is the directory directly under rel in which the path relative to the main
+//root is, like /src for /src/cmd/ls.c under "", "." for rel itself
+func TopDir(path string, rel string) string {
+	p := strings.TrimPrefix(path, rel)
+	if p == "" {
+		return "."
+	}
+	return "/" + strings.SplitN(p[1:], "/", 2)[0]
+}
+
+//PathOf is the path in the snapshot for a path relative to the main root
+func (s *Snapshot) PathOf(rel string, roots Roots) string {
+	return s.Path + "/" + roots.RootName + rel
+}
+
+//ErrOutside is returned by Resolve for the paths which lead out of the snapshot
+var ErrOutside = errors.New("path out of the snapshot")
+
+//Resolve returns the path in the snapshot for a path relative to the main root, with the
+//symbolic links evaluated, and its information. It fails with ErrOutside if the symbolic links
+//lead out of the snapshot, so that serving the snapshot does not serve other files.
+func (s *Snapshot) Resolve(rel string, roots Roots) (path string, fi os.FileInfo, err error) {
+	root, err := filepath.EvalSymlinks(s.PathOf("", roots))
+	if err != nil {
+		return "", nil, err
+	}
+	path, err = filepath.EvalSymlinks(s.PathOf(rel, roots))
+	if err != nil {
+		return "", nil, err
+	}
+	if path != root && !strings.HasPrefix(path, root+"/") {
+		return "", nil, ErrOutside
+	}
+	fi, err = os.Stat(path)
+	return path, fi, err
+}
+
+//Until returns the snapshots which are not after until,
+//a zero until means no limit
+func Until(snaps []Snapshot, until DumpDate) []Snapshot {
+	if until == (DumpDate{}) {
+		return snaps
+	}
+	for i := range snaps {
+		if snaps[i].Date.IsAfter(until) {
+			return snaps[:i]
+		}
+	}
+	return snaps
+}
+
+//A WalkFunc is called by WalkSnapshot for each file, with its path relative to the main root
+type WalkFunc func(rel string, fi os.FileInfo) error
+
+//A PruneFunc tells WalkSnapshot not to descend into a directory
+type PruneFunc func(rel string, fi os.FileInfo) bool
+
+//WalkSnapshot walks the tree under rel in the snapshot in lexical order calling fn
+//for each file and directory, including rel. The directories for which prune returns true
+//(prune may be nil) are not descended into. If rel does not exist in the snapshot, it does nothing.
+func WalkSnapshot(s *Snapshot, roots Roots, rel string, prune PruneFunc, fn WalkFunc) error {
+	root := s.PathOf(rel, roots)
+	if _, err := os.Lstat(root); os.IsNotExist(err) {
+		return nil
+	}
+	skip := len(s.PathOf("", roots))
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			Dprintf("walk %s: %s\n", path, err)
+			return nil
+		}
+		r := path[skip:]
+		if err := fn(r, fi); err != nil {
+			return err
+		}
+		if fi.IsDir() && path != root && prune != nil && prune(r, fi) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+//WalkSnapshots calls WalkSnapshot for each of the snapshots, walking up to nProcs of them at the
+//same time. fn gets the index of the snapshot and has to be safe for concurrent use.
+//The first error is returned, after all the walks are done.
+func WalkSnapshots(snaps []Snapshot, roots Roots, rel string, prune PruneFunc, nProcs int, fn func(i int, rel string, fi os.FileInfo) error) (err error) {
+	if nProcs < 1 {
+		nProcs = 1
+	}
+	var lk sync.Mutex
+	sem := make(chan struct{}, nProcs)
+	var wg sync.WaitGroup
+	for i := range snaps {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			Dprintf("walking %s\n", snaps[i].Path)
+			werr := WalkSnapshot(&snaps[i], roots, rel, prune, func(r string, fi os.FileInfo) error {
+				return fn(i, r, fi)
+			})
+			lk.Lock()
+			if werr != nil && err == nil {
+				err = werr
+			}
+			lk.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	return err
+}
+
+//Prune returns a function for WalkSnapshot which prunes the directories
+//with names matching any of the patterns (as in filepath.Match)
+func Prune(patterns []string) PruneFunc {
+	return func(rel string, fi os.FileInfo) bool {
+		for _, pat := range patterns {
+			if ok, _ := filepath.Match(pat, fi.Name()); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,137 @@
+package dnav_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestRelPath(t *testing.T) {
+	var r dnav.Roots
+	os.Setenv(dnav.MainRootVar, "/adfadf:/2rsdfewr2/asf3qer:/bin")
+	os.Setenv(dnav.MainDumpVar, "/adf13123adf:/sdfsd/asf3qer:/etc")
+	dnav.RdRoots(&r)
+
+	paths := []struct {
+		path string
+		rel  string
+	}{
+		{"/bin", ""},
+		{"/bin/ls", "/ls"},
+		{GoodDumpPath, ""},
+		{GoodDumpPath + "/a/b/", "/a/b"},
+	}
+	for _, p := range paths {
+		rel, err := dnav.RelPath(p.path, r)
+		if err != nil || rel != p.rel {
+			t.Fatalf("bad relative path for %s: [%s] %s", p.path, rel, err)
+		}
+		if p.rel != "" && dnav.LivePath(rel, r) != "/bin"+p.rel {
+			t.Fatalf("bad live path for %s: %s", rel, dnav.LivePath(rel, r))
+		}
+	}
+	for _, p := range []string{"/binary", "/usr/bin", "/etc/2017/0415", "/etc/2017/0415/0036/usr"} {
+		if rel, err := dnav.RelPath(p, r); err == nil {
+			t.Fatalf("should error, bad path %s: %s", p, rel)
+		}
+	}
+}
+
+func TestTopDir(t *testing.T) {
+	for _, c := range []struct{ path, rel, top string }{
+		{"/src/cmd/ls.c", "", "/src"},
+		{"/src", "", "/src"},
+		{"/src/cmd/ls.c", "/src", "/cmd"},
+		{"/src/ls.c", "/src", "/ls.c"},
+		{"/src", "/src", "."},
+	} {
+		if top := dnav.TopDir(c.path, c.rel); top != c.top {
+			t.Fatalf("bad top directory of %s under %q: %s", c.path, c.rel, top)
+		}
+	}
+}
+
+func TestWalkSnapshot(t *testing.T) {
+	r, tmproot := mkTestDump(t, "walk", []int{3})
+	defer os.RemoveAll(tmproot)
+	snaps, err := dnav.ListSnapshots(r, dnav.DumpDate{}, 1)
+	if err != nil || len(snaps) != 2 {
+		t.Fatalf("bad snapshots %v: %s", snaps, err)
+	}
+	s := &snaps[0]
+	for _, p := range []string{"/a/b", "/a/node_modules/c", "/d"} {
+		os.MkdirAll(s.PathOf(p, r), 0700)
+	}
+
+	var walked []string
+	err = dnav.WalkSnapshot(s, r, "/a", dnav.Prune([]string{"node_*"}), func(rel string, fi os.FileInfo) error {
+		walked = append(walked, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if len(walked) != 3 || walked[0] != "/a" || walked[1] != "/a/b" || walked[2] != "/a/node_modules" {
+		t.Fatalf("bad walk %v", walked)
+	}
+	err = dnav.WalkSnapshot(&snaps[1], r, "/a", nil, func(rel string, fi os.FileInfo) error {
+		t.Fatalf("should not walk %s", rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+}
+
+func TestWalkSnapshots(t *testing.T) {
+	r, tmproot := mkTestDump(t, "walksnapshots", []int{3, 4, 5})
+	defer os.RemoveAll(tmproot)
+	snaps, err := dnav.ListSnapshots(r, dnav.DumpDate{}, 1)
+	if err != nil || len(snaps) != 6 {
+		t.Fatalf("bad snapshots %v: %s", snaps, err)
+	}
+	for i := range snaps {
+		if i%2 == 0 {
+			os.MkdirAll(snaps[i].PathOf("/a", r), 0700)
+		}
+	}
+	var lk sync.Mutex
+	seen := make(map[int]int)
+	err = dnav.WalkSnapshots(snaps, r, "/a", nil, 4, func(i int, rel string, fi os.FileInfo) error {
+		lk.Lock()
+		defer lk.Unlock()
+		seen[i]++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if len(seen) != 3 || seen[0] != 1 || seen[2] != 1 || seen[4] != 1 {
+		t.Fatalf("bad walks %v", seen)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	r, tmproot := mkTestDump(t, "resolve", []int{3})
+	defer os.RemoveAll(tmproot)
+	snaps, err := dnav.ListSnapshots(r, dnav.DumpDate{}, 1)
+	if err != nil || len(snaps) != 2 {
+		t.Fatalf("bad snapshots %v: %s", snaps, err)
+	}
+	s := &snaps[0]
+	os.MkdirAll(s.PathOf("/a", r), 0700)
+	os.WriteFile(s.PathOf("/a/f", r), []byte("f"), 0600)
+	os.Symlink("f", s.PathOf("/a/in", r))
+	os.Symlink(tmproot, s.PathOf("/a/out", r))
+	if p, fi, err := s.Resolve("/a/in", r); err != nil || p != s.PathOf("/a/f", r) || fi.Size() != 1 {
+		t.Fatalf("bad resolve %s %s", p, err)
+	}
+	if _, _, err := s.Resolve("/a/out/2017", r); err != dnav.ErrOutside {
+		t.Fatalf("should error, out of the snapshot: %s", err)
+	}
+	if _, _, err := s.Resolve("/a/nothere", r); !os.IsNotExist(err) {
+		t.Fatalf("should error, does not exist: %s", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug      bool
+	removeFlag bool
+	quiet      bool
+	policy     dnav.Policy
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	r := flag.Bool("r", false, "really remove the dumps pruned")
+	q := flag.Bool("q", false, "print only the dumps pruned")
+	k := flag.String("k", "all:2d,hourly:2w,daily:6m,monthly", "retention policy")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	removeFlag = *r
+	quiet = *q
+	var err error
+	if policy, err = dnav.ParsePolicy(*k); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dprune: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dprune [-Drq] [-k=policy]")
+}
+
+//markedSnapshots returns the snapshots the markers point to, which are never pruned.
+//They are compared as files, the paths in the markers may be written differently.
+func markedSnapshots(roots dnav.Roots) (marked []os.FileInfo, err error) {
+	for _, m := range []string{dnav.FirstMarker, dnav.CurrentMarker, dnav.CurrentChkMarker} {
+		p, err := dnav.ReadMarker(m, roots)
+		if err != nil {
+			return nil, err
+		}
+		if p == "" {
+			continue
+		}
+		Dprintf("marker %s: %s\n", m, p)
+		fi, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue //current_chk while the dump is written
+		}
+		if err != nil {
+			return nil, err
+		}
+		marked = append(marked, fi)
+	}
+	return marked, nil
+}
+
+//plan returns which of the snapshots are kept by the policy at the time now,
+//those the markers point to always are
+func plan(snaps []dnav.Snapshot, roots dnav.Roots, now time.Time) (keep []bool, err error) {
+	marked, err := markedSnapshots(roots)
+	if err != nil {
+		return nil, err
+	}
+	keep = policy.Plan(snaps, now, func(s *dnav.Snapshot) bool {
+		fi, err := os.Stat(s.Path)
+		if err != nil {
+			return true //not to remove what we cannot tell
+		}
+		for _, mfi := range marked {
+			if os.SameFile(fi, mfi) {
+				return true
+			}
+		}
+		return false
+	})
+	return keep, nil
+}
+
+//remove removes a snapshot and the directories of its day and year if they are left empty
+func remove(s *dnav.Snapshot) error {
+	if err := os.RemoveAll(s.Path); err != nil {
+		return err
+	}
+	day := filepath.Dir(s.Path)
+	if err := os.Remove(day); err == nil {
+		os.Remove(filepath.Dir(day))
+	}
+	return nil
+}
+
+func main() {
+	var roots dnav.Roots
+
+	rdFlags()
+	if len(flag.Args()) != 0 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+	Dprintf("policy %s\n", policy)
+
+	snaps, err := dnav.ListSnapshots(roots, dnav.DumpDate{}, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(snaps) == 0 {
+		log.Fatal("no dumps")
+	}
+	keep, err := plan(snaps, roots, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	failed := false
+	for i := range snaps {
+		s := &snaps[i]
+		if keep[i] {
+			if !quiet {
+				fmt.Printf("keep\t%s\n", s.Path)
+			}
+			continue
+		}
+		fmt.Printf("prune\t%s\n", s.Path)
+		if removeFlag {
+			if err := remove(s); err != nil {
+				fmt.Fprintf(os.Stderr, "dprune: %s\n", err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
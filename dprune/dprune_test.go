@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+func TestPlanMarked(t *testing.T) {
+	var roots dnav.Roots
+	tmp := t.TempDir()
+	live := tmp + "/ROOT"
+	os.MkdirAll(live, 0755)
+	os.MkdirAll(tmp+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmp+"/dump")
+	dnav.RdRoots(&roots)
+
+	t1 := time.Date(2017, 5, 10, 16, 5, 0, 0, time.Local)
+	var taken []dnav.Snapshot
+	for i := 0; i < 4; i++ {
+		os.WriteFile(live+"/f.txt", []byte{byte('0' + i)}, 0644)
+		s, _, err := dnav.TakeSnapshot(roots, t1.Add(time.Duration(i)*24*time.Hour), nil)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		taken = append(taken, s)
+	}
+	if err := dnav.WriteMarker(dnav.CurrentMarker, &taken[1], roots); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	//the paths of the snapshots are not written as in the markers
+	roots.DumpRoot += "//"
+	snaps, err := dnav.ListSnapshots(roots, dnav.DumpDate{}, 1)
+	if err != nil || len(snaps) != 4 {
+		t.Fatalf("should list the snapshots %s", err)
+	}
+	if policy, err = dnav.ParsePolicy("all:1d"); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	keep, err := plan(snaps, roots, t1.Add(30*24*time.Hour))
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	//first, current and the newest are kept
+	if len(keep) != 4 || !keep[0] || !keep[1] || keep[2] || !keep[3] {
+		t.Fatalf("bad plan %v", keep)
+	}
+}
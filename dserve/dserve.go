@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug   bool
+	addr    string
+	nProcs  int
+	refresh time.Duration
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	a := flag.String("a", "localhost:8080", "address to listen to")
+	p := flag.Int("p", runtime.NumCPU(), "number of dumps to look up in parallel")
+	r := flag.Duration("r", time.Minute, "how often the list of dumps is refreshed")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	addr = *a
+	nProcs = *p
+	refresh = *r
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dserve: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dserve [-D] [-a=addr] [-p=nprocs] [-r=refresh]")
+}
+
+const maxDiffSize = dnav.DefMaxSize //bigger files are not shown or diffed
+
+var (
+	errBadRequest = errors.New("bad request")
+	snapshotRe    = regexp.MustCompile(`^[0-9]{4}/[0-9]{4}/[0-9]{4}$`)
+)
+
+//server serves the dump read only, as HTML pages and as JSON under /api
+type server struct {
+	roots   dnav.Roots
+	nProcs  int
+	refresh time.Duration
+
+	mu     sync.Mutex
+	snaps  []dnav.Snapshot //the list of dumps, listed at listed
+	listed time.Time
+}
+
+func newServer(roots dnav.Roots, nProcs int, refresh time.Duration) http.Handler {
+	s := &server{roots: roots, nProcs: nProcs, refresh: refresh}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/at/", s.handleAt)
+	mux.HandleFunc("/raw/", s.handleRaw)
+	mux.HandleFunc("/versions/", s.handleVersions)
+	mux.HandleFunc("/diff/", s.handleDiff)
+	mux.HandleFunc("/api/snapshots", s.handleIndex)
+	mux.HandleFunc("/api/at/", s.handleAt)
+	mux.HandleFunc("/api/versions/", s.handleVersions)
+	mux.HandleFunc("/api/diff/", s.handleDiff)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Dprintf("%s %s\n", r.Method, r.URL)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "read only", http.StatusMethodNotAllowed)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func isAPI(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+//relPath is the path relative to the main root at the end of the url path
+func relPath(p string) string {
+	rel := path.Clean("/" + p)
+	if rel == "/" {
+		return ""
+	}
+	return rel
+}
+
+//snapshot finds the snapshot with the name yyyy/mmdd/hhmm
+func (s *server) snapshot(name string) (snap dnav.Snapshot, err error) {
+	if !snapshotRe.MatchString(name) {
+		return snap, errBadRequest
+	}
+	snap.Path = s.roots.DumpRoot + "/" + name
+	fi, err := os.Stat(snap.Path)
+	if err != nil {
+		return snap, err
+	}
+	if !fi.IsDir() {
+		return snap, os.ErrNotExist
+	}
+	snap.Date, err = dnav.ParseDumpPath(snap.Path, s.roots)
+	return snap, err
+}
+
+//snapshots returns the list of dumps, walking the dump again only
+//when the list is older than the refresh period
+func (s *server) snapshots() ([]dnav.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snaps != nil && time.Since(s.listed) < s.refresh {
+		return s.snaps, nil
+	}
+	snaps, err := dnav.ListSnapshots(s.roots, dnav.DumpDate{}, s.nProcs)
+	if err != nil {
+		return nil, err
+	}
+	s.snaps, s.listed = snaps, time.Now()
+	return snaps, nil
+}
+
+//splitAt splits a url path of the form prefix/yyyy/mmdd/hhmm/rel into the snapshot and rel
+func (s *server) splitAt(p string, prefix string) (snap dnav.Snapshot, rel string, err error) {
+	els := strings.SplitN(strings.TrimPrefix(p, prefix), "/", 4)
+	if len(els) < 3 {
+		return snap, "", errBadRequest
+	}
+	if len(els) == 4 {
+		rel = relPath(els[3])
+	}
+	snap, err = s.snapshot(strings.Join(els[:3], "/"))
+	return snap, rel, err
+}
+
+func (s *server) error(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case os.IsNotExist(err):
+		status = http.StatusNotFound
+	case err == dnav.ErrOutside:
+		status = http.StatusForbidden
+	case err == errBadRequest:
+		status = http.StatusBadRequest
+	}
+	Dprintf("error %d: %s\n", status, err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (s *server) json(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(v); err != nil {
+		Dprintf("json: %s\n", err)
+	}
+}
+
+func (s *server) html(w http.ResponseWriter, name string, v interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.ExecuteTemplate(w, name, v); err != nil {
+		Dprintf("template %s: %s\n", name, err)
+	}
+}
+
+//fileInfo is the information of a file in the JSON API and the pages
+type fileInfo struct {
+	Name    string
+	Size    int64
+	Mode    string
+	ModTime time.Time
+	IsDir   bool
+}
+
+func newFileInfo(fi os.FileInfo) *fileInfo {
+	if fi == nil {
+		return nil
+	}
+	return &fileInfo{fi.Name(), fi.Size(), fi.Mode().String(), fi.ModTime(), fi.IsDir()}
+}
+
+type snapshotInfo struct {
+	Name string //yyyy/mmdd/hhmm
+	Time time.Time
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "/api/snapshots" {
+		s.error(w, os.ErrNotExist)
+		return
+	}
+	snaps, err := s.snapshots()
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	infos := make([]snapshotInfo, len(snaps))
+	for i := range snaps {
+		infos[i] = snapshotInfo{snaps[i].Date.Name(), snaps[i].Date.Time()}
+	}
+	if isAPI(r) {
+		s.json(w, infos)
+		return
+	}
+	//newest first
+	for i, j := 0, len(infos)-1; i < j; i, j = i+1, j-1 {
+		infos[i], infos[j] = infos[j], infos[i]
+	}
+	s.html(w, "index", struct {
+		Root  string
+		Snaps []snapshotInfo
+	}{s.roots.RootName, infos})
+}
+
+//atPage is a directory or file of a snapshot
+type atPage struct {
+	Snapshot string
+	Rel      string
+	Info     *fileInfo
+	Entries  []*fileInfo `json:",omitempty"`
+	Text     string      `json:"-"`
+}
+
+func (s *server) handleAt(w http.ResponseWriter, r *http.Request) {
+	prefix := "/at/"
+	if isAPI(r) {
+		prefix = "/api/at/"
+	}
+	snap, rel, err := s.splitAt(r.URL.Path, prefix)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	p, fi, err := snap.Resolve(rel, s.roots)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	pg := &atPage{Snapshot: snap.Date.Name(), Rel: rel, Info: newFileInfo(fi)}
+	if fi.IsDir() {
+		files, err := ioutil.ReadDir(p)
+		if err != nil {
+			s.error(w, err)
+			return
+		}
+		for _, f := range files {
+			pg.Entries = append(pg.Entries, newFileInfo(f))
+		}
+	} else if !isAPI(r) && fi.Mode().IsRegular() && fi.Size() <= maxDiffSize {
+		if txt, err := readText(p); err == nil {
+			pg.Text = txt
+		}
+	}
+	if isAPI(r) {
+		s.json(w, pg)
+		return
+	}
+	s.html(w, "at", pg)
+}
+
+func (s *server) handleRaw(w http.ResponseWriter, r *http.Request) {
+	snap, rel, err := s.splitAt(r.URL.Path, "/raw/")
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	p, fi, err := snap.Resolve(rel, s.roots)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		s.error(w, errBadRequest)
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	defer f.Close()
+	//the files of the dump should not run in the pages of the server
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+type versionInfo struct {
+	Kind     string
+	Snapshot string
+	Prev     string `json:",omitempty"` //the previous version, to diff with
+	Info     *fileInfo
+}
+
+func (s *server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	prefix := "/versions"
+	if isAPI(r) {
+		prefix = "/api/versions"
+	}
+	rel := relPath(strings.TrimPrefix(r.URL.Path, prefix))
+	snaps, err := s.snapshots()
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	versions, err := dnav.History(snaps, s.roots, rel, s.nProcs)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	infos := make([]versionInfo, 0, len(versions))
+	prev := ""
+	for _, v := range versions {
+		vi := versionInfo{Kind: v.Kind, Snapshot: v.Snapshot.Date.Name(), Info: newFileInfo(v.Info)}
+		if v.Kind == dnav.Write || v.Kind == dnav.Wstat {
+			vi.Prev = prev
+		}
+		if v.Kind != dnav.Delete {
+			prev = vi.Snapshot
+		}
+		infos = append(infos, vi)
+	}
+	if isAPI(r) {
+		s.json(w, infos)
+		return
+	}
+	s.html(w, "versions", struct {
+		Rel      string
+		Versions []versionInfo
+	}{rel, infos})
+}
+
+//readText reads a file, failing if it is not text
+func readText(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", errors.New("not a text file")
+	}
+	return string(b), nil
+}
+
+type diffPage struct {
+	Rel  string
+	A, B string
+	Rows []dnav.DiffRow
+	Msg  string `json:",omitempty"` //why there are no rows
+}
+
+func (s *server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	prefix := "/diff"
+	if isAPI(r) {
+		prefix = "/api/diff"
+	}
+	rel := relPath(strings.TrimPrefix(r.URL.Path, prefix))
+	pg := &diffPage{Rel: rel, A: r.FormValue("a"), B: r.FormValue("b")}
+	var txts [2]string
+	for i, name := range []string{pg.A, pg.B} {
+		snap, err := s.snapshot(name)
+		if err != nil {
+			s.error(w, err)
+			return
+		}
+		p, fi, err := snap.Resolve(rel, s.roots)
+		if err != nil {
+			s.error(w, err)
+			return
+		}
+		if !fi.Mode().IsRegular() || fi.Size() > maxDiffSize {
+			pg.Msg = "not a regular file or too big"
+			continue
+		}
+		if txts[i], err = readText(p); err != nil {
+			pg.Msg = err.Error()
+		}
+	}
+	if pg.Msg == "" {
+		pg.Rows = dnav.SideBySide(txts[0], txts[1])
+	}
+	if isAPI(r) {
+		s.json(w, pg)
+		return
+	}
+	s.html(w, "diff", pg)
+}
+
+var pages = template.Must(template.New("").Funcs(template.FuncMap{
+	"join": path.Join,
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { padding: 0 0.5em; text-align: left; vertical-align: top; }
+pre, .diff td { font-family: monospace; white-space: pre; }
+.diff .changed { background: #fed; }
+.diff .num { color: #888; text-align: right; }
+</style></head><body>
+<p><a href="/">dumps</a></p>
+{{end}}
+
+{{define "index"}}{{template "head" "dumps"}}
+<h1>dumps of {{.Root}}</h1>
+<ul>{{range .Snaps}}<li><a href="/at/{{.Name}}/">{{.Name}}</a></li>
+{{end}}</ul>
+</body></html>{{end}}
+
+{{define "at"}}{{template "head" (join .Snapshot .Rel)}}
+<h1><a href="/at/{{.Snapshot}}/">{{.Snapshot}}</a>{{.Rel}}</h1>
+{{if .Info.IsDir}}<p><a href="/versions{{.Rel}}">versions</a></p>
+<table>{{$dir := .}}{{range .Entries}}<tr>
+<td><a href="/at/{{join $dir.Snapshot $dir.Rel .Name}}{{if .IsDir}}/{{end}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td>
+<td>{{.Mode}}</td><td>{{.Size}}</td><td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td>
+<td><a href="/versions{{$dir.Rel}}/{{.Name}}">versions</a></td></tr>
+{{end}}</table>
+{{else}}<p>{{.Info.Mode}} {{.Info.Size}} {{.Info.ModTime.Format "2006-01-02 15:04:05"}}
+<a href="/raw/{{join .Snapshot .Rel}}">raw</a> <a href="/versions{{.Rel}}">versions</a></p>
+{{if .Text}}<pre>{{.Text}}</pre>{{end}}
+{{end}}</body></html>{{end}}
+
+{{define "versions"}}{{template "head" (printf "versions of %s" .Rel)}}
+<h1>versions of {{.Rel}}</h1>
+<form action="/diff{{.Rel}}">
+<table><tr><th>a</th><th>b</th><th>dump</th><th>change</th><th>mode</th><th>size</th><th>modified</th><th></th></tr>
+{{$rel := .Rel}}{{range .Versions}}<tr>
+{{if .Info}}<td><input type="radio" name="a" value="{{.Snapshot}}"></td><td><input type="radio" name="b" value="{{.Snapshot}}"></td>
+<td><a href="/at/{{join .Snapshot $rel}}">{{.Snapshot}}</a></td><td>{{.Kind}}</td>
+<td>{{.Info.Mode}}</td><td>{{.Info.Size}}</td><td>{{.Info.ModTime.Format "2006-01-02 15:04:05"}}</td>
+<td>{{if not .Info.IsDir}}<a href="/raw/{{join .Snapshot $rel}}">raw</a>{{end}}
+{{if .Prev}}<a href="/diff{{$rel}}?a={{.Prev}}&amp;b={{.Snapshot}}">diff</a>{{end}}</td>
+{{else}}<td></td><td></td><td>{{.Snapshot}}</td><td>{{.Kind}}</td>{{end}}
+</tr>
+{{end}}</table>
+<p><input type="submit" value="diff a and b"></p>
+</form>
+</body></html>{{end}}
+
+{{define "diff"}}{{template "head" (printf "diff of %s" .Rel)}}
+<h1>{{.Rel}}</h1>
+{{if .Msg}}<p>{{.Msg}}</p>{{else}}
+<table class="diff"><tr><th></th><th><a href="/at/{{join .A .Rel}}">{{.A}}</a></th>
+<th></th><th><a href="/at/{{join .B .Rel}}">{{.B}}</a></th></tr>
+{{range .Rows}}<tr{{if not .Equal}} class="changed"{{end}}>
+<td class="num">{{if .Line}}{{.Line}}{{end}}</td><td>{{.Text}}</td>
+<td class="num">{{if .Line2}}{{.Line2}}{{end}}</td><td>{{.Text2}}</td></tr>
+{{end}}</table>{{end}}
+</body></html>{{end}}
+`))
+
+func main() {
+	var roots dnav.Roots
+
+	rdFlags()
+	if len(flag.Args()) != 0 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	log.Printf("serving %s on http://%s/", roots.DumpRoot, addr)
+	log.Fatal(http.ListenAndServe(addr, newServer(roots, nProcs, refresh)))
+}
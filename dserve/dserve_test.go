@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+//mkDump takes two snapshots of a main root with a file changed between them
+func mkDump(t *testing.T) (roots dnav.Roots, snaps []dnav.Snapshot) {
+	tmp := t.TempDir()
+	live := tmp + "/ROOT"
+	os.MkdirAll(live+"/a", 0755)
+	os.MkdirAll(tmp+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, live)
+	os.Setenv(dnav.MainDumpVar, tmp+"/dump")
+	dnav.RdRoots(&roots)
+
+	os.WriteFile(live+"/a/f.txt", []byte("one\ntwo\n"), 0644)
+	os.WriteFile(tmp+"/secret", []byte("secret\n"), 0644)
+	os.Symlink(tmp+"/secret", live+"/a/out")
+	t1 := time.Date(2017, 5, 10, 16, 5, 0, 0, time.Local)
+	if _, _, err := dnav.TakeSnapshot(roots, t1, nil); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	os.WriteFile(live+"/a/f.txt", []byte("one\n2\n"), 0644)
+	if _, _, err := dnav.TakeSnapshot(roots, t1.Add(24*time.Hour), nil); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	snaps, err := dnav.ListSnapshots(roots, dnav.DumpDate{}, 1)
+	if err != nil || len(snaps) != 2 {
+		t.Fatalf("should list the snapshots %s", err)
+	}
+	return roots, snaps
+}
+
+func get(t *testing.T, srv *httptest.Server, url string, status int) string {
+	resp, err := http.Get(srv.URL + url)
+	if err != nil {
+		t.Fatalf("get %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("get %s: %s", url, err)
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("get %s: status %d, should be %d", url, resp.StatusCode, status)
+	}
+	return string(b)
+}
+
+func getJSON(t *testing.T, srv *httptest.Server, url string, v interface{}) {
+	if err := json.Unmarshal([]byte(get(t, srv, url, http.StatusOK)), v); err != nil {
+		t.Fatalf("get %s: %s", url, err)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	roots, _ := mkDump(t)
+	srv := httptest.NewServer(newServer(roots, 2, time.Minute))
+	defer srv.Close()
+
+	var snaps []snapshotInfo
+	getJSON(t, srv, "/api/snapshots", &snaps)
+	if len(snaps) != 2 || snaps[0].Name != "2017/0510/1605" || snaps[1].Name != "2017/0511/1605" {
+		t.Fatalf("bad snapshots %v", snaps)
+	}
+	var at atPage
+	getJSON(t, srv, "/api/at/2017/0510/1605/a", &at)
+	if !at.Info.IsDir || len(at.Entries) != 2 || at.Entries[0].Name != "f.txt" {
+		t.Fatalf("bad directory %+v", at)
+	}
+	var versions []versionInfo
+	getJSON(t, srv, "/api/versions/a/f.txt", &versions)
+	if len(versions) != 2 || versions[0].Kind != dnav.Create || versions[1].Kind != dnav.Write ||
+		versions[1].Prev != "2017/0510/1605" || versions[1].Info.Size != 6 {
+		t.Fatalf("bad versions %+v", versions)
+	}
+	var diff diffPage
+	getJSON(t, srv, "/api/diff/a/f.txt?a=2017/0510/1605&b=2017/0511/1605", &diff)
+	if len(diff.Rows) != 2 || !diff.Rows[0].Equal || diff.Rows[1].Text != "two" || diff.Rows[1].Text2 != "2" {
+		t.Fatalf("bad diff %+v", diff)
+	}
+	if txt := get(t, srv, "/raw/2017/0510/1605/a/f.txt", http.StatusOK); txt != "one\ntwo\n" {
+		t.Fatalf("bad raw file %s", txt)
+	}
+}
+
+func TestPages(t *testing.T) {
+	roots, _ := mkDump(t)
+	srv := httptest.NewServer(newServer(roots, 2, time.Minute))
+	defer srv.Close()
+
+	pages := []struct {
+		url  string
+		want string
+	}{
+		{"/", `href="/at/2017/0511/1605/"`},
+		{"/at/2017/0511/1605/", `href="/at/2017/0511/1605/a/"`},
+		{"/at/2017/0511/1605/a/", `href="/versions/a/f.txt"`},
+		{"/at/2017/0511/1605/a/f.txt", "one\n2\n"},
+		{"/versions/a/f.txt", `href="/diff/a/f.txt?a=2017%2f0510%2f1605&amp;b=2017%2f0511%2f1605"`},
+		{"/diff/a/f.txt?a=2017/0510/1605&b=2017/0511/1605", "two"},
+	}
+	for _, p := range pages {
+		if html := get(t, srv, p.url, http.StatusOK); !strings.Contains(html, p.want) {
+			t.Fatalf("page %s should contain %s:\n%s", p.url, p.want, html)
+		}
+	}
+
+	errs := []struct {
+		url    string
+		status int
+	}{
+		{"/raw/2017/0510/1605/a/out", http.StatusForbidden},
+		{"/raw/2017/0510/1605/a/nothere", http.StatusNotFound},
+		{"/at/2017/0509/1605/", http.StatusNotFound},
+		{"/at/2017/05x0/1605/", http.StatusBadRequest},
+		{"/diff/a/f.txt?a=bad", http.StatusBadRequest},
+		{"/nothere", http.StatusNotFound},
+	}
+	for _, e := range errs {
+		get(t, srv, e.url, e.status)
+	}
+	resp, err := http.Post(srv.URL+"/at/2017/0510/1605/", "text/plain", strings.NewReader(""))
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("should not allow writing %v %s", resp, err)
+	}
+	resp.Body.Close()
+}
+
+func TestRefresh(t *testing.T) {
+	roots, _ := mkDump(t)
+	srv := httptest.NewServer(newServer(roots, 2, time.Hour))
+	defer srv.Close()
+
+	var snaps []snapshotInfo
+	getJSON(t, srv, "/api/snapshots", &snaps)
+	t3 := time.Date(2017, 5, 12, 16, 5, 0, 0, time.Local)
+	if _, _, err := dnav.TakeSnapshot(roots, t3, nil); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	getJSON(t, srv, "/api/snapshots", &snaps)
+	if len(snaps) != 2 {
+		t.Fatalf("should keep the list until it is refreshed, got %v", snaps)
+	}
+
+	srv2 := httptest.NewServer(newServer(roots, 2, 0))
+	defer srv2.Close()
+	getJSON(t, srv2, "/api/snapshots", &snaps)
+	if len(snaps) != 3 {
+		t.Fatalf("should list the new dump, got %v", snaps)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug        bool
+	verbose      bool
+	manifestFlag bool
+	nProcs       int
+	prunes       []string
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	v := flag.Bool("v", false, "print the errors and what was copied")
+	m := flag.Bool("m", false, "write the manifest of the dump")
+	p := flag.Int("p", runtime.NumCPU(), "number of files to hash in parallel for the manifest")
+	x := flag.String("x", "", "comma separated names of directories not to copy")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	verbose = *v
+	manifestFlag = *m
+	nProcs = *p
+	if *x != "" {
+		prunes = strings.Split(*x, ",")
+	}
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dsnap: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dsnap [-Dvm] [-p=nprocs] [-x=dir,dir...]")
+}
+
+func main() {
+	var roots dnav.Roots
+
+	rdFlags()
+	if len(flag.Args()) != 0 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+	if _, err := os.Stat(roots.MainRoot); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := os.Stat(roots.DumpRoot); err != nil {
+		log.Fatal(err)
+	}
+
+	var prune dnav.PruneFunc
+	if len(prunes) != 0 {
+		prune = dnav.Prune(prunes)
+	}
+	s, stats, err := dnav.TakeSnapshot(roots, time.Now(), prune)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if manifestFlag {
+		prev, err := dnav.PrevSnapshot(s.Date, roots)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, skipped, err := dnav.WriteManifest(&s, prev, roots, nProcs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, rel := range skipped {
+			fmt.Fprintf(os.Stderr, "dsnap: %s: newline in the name, not in the manifest\n", s.PathOf(rel, roots))
+		}
+		Dprintf("manifest of %d files\n", n)
+	}
+	if verbose {
+		for _, e := range stats.Errors {
+			fmt.Fprintf(os.Stderr, "dsnap: %s\n", e)
+		}
+		fmt.Printf("%s\t%d files\t%d linked\t%d bytes copied\t%d errors\n", s.Path,
+			stats.Files, stats.Linked, stats.Copied, len(stats.Errors))
+	} else {
+		fmt.Println(s.Path)
+	}
+	if len(stats.Errors) != 0 {
+		if !verbose {
+			fmt.Fprintf(os.Stderr, "dsnap: %d errors, see %s/%s\n", len(stats.Errors), s.Path, dnav.ErrorsFile)
+		}
+		os.Exit(1)
+	}
+}
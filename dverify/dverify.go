@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+
+	"github.com/paurea/dump/dnav"
+)
+
+var (
+	debug     bool
+	writeFlag bool
+	nProcs    int
+	fromStr   string
+	untilStr  string
+)
+
+func rdFlags() {
+	db := flag.Bool("D", false, "debug flag")
+	w := flag.Bool("w", false, "write the manifests of the dumps which do not have one")
+	p := flag.Int("p", runtime.NumCPU(), "number of files to hash in parallel")
+	s := flag.String("s", "", "earliest date or dump path")
+	e := flag.String("e", "", "latest date or dump path")
+	flag.Parse()
+
+	debug = *db
+	dnav.Debug = *db
+	writeFlag = *w
+	nProcs = *p
+	fromStr = *s
+	untilStr = *e
+}
+
+func Dprintf(format string, a ...interface{}) (n int, err error) {
+
+	if !debug {
+		return 0, nil
+	}
+	return fmt.Fprintf(os.Stderr, "dverify: "+format, a...)
+}
+
+func usage() {
+	log.Fatal("dverify [-Dw] [-p=nprocs] [-s=earliest] [-e=latest] [date]")
+}
+
+//snapshots are the snapshots to verify, the one for the date or the ones between -s and -e
+func snapshots(args []string, roots dnav.Roots) []dnav.Snapshot {
+	var (
+		from, until dnav.DumpDate
+		err         error
+	)
+	if len(args) == 1 {
+		d, err := dnav.ParseDateEnd(args[0], roots)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s, err := dnav.FindSnapshot(d, roots)
+		if err != nil {
+			log.Fatal(err)
+		}
+		Dprintf("%s is %s\n", args[0], s.Path)
+		return []dnav.Snapshot{s}
+	}
+	if fromStr != "" {
+		if from, err = dnav.ParseDate(fromStr, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if untilStr != "" {
+		if until, err = dnav.ParseDateEnd(untilStr, roots); err != nil {
+			log.Fatal(err)
+		}
+	}
+	snaps, err := dnav.ListSnapshots(roots, from, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dnav.Until(snaps, until)
+}
+
+//verify checks a snapshot against its manifest, or writes it if there is none
+//and writeFlag is set, and reports if there are problems
+func verify(s *dnav.Snapshot, prev *dnav.Snapshot, roots dnav.Roots) (bad bool) {
+	if _, err := os.Stat(s.ManifestPath()); os.IsNotExist(err) {
+		if !writeFlag {
+			fmt.Printf("#nomanifest\t%s\n", s.Path)
+			return false
+		}
+		n, skipped, err := dnav.WriteManifest(s, prev, roots, nProcs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dverify: %s: %s\n", s.Path, err)
+			return true
+		}
+		for _, rel := range skipped {
+			fmt.Fprintf(os.Stderr, "dverify: %s: newline in the name, not in the manifest\n", s.PathOf(rel, roots))
+		}
+		fmt.Printf("#manifest\t%s\t%d files\n", s.Path, n)
+		return false
+	}
+	Dprintf("verifying %s\n", s.Path)
+	problems, err := dnav.VerifySnapshot(s, roots, nProcs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dverify: %s: %s\n", s.Path, err)
+		return true
+	}
+	for _, p := range problems {
+		if p.Msg != "" {
+			fmt.Printf("#%s\t%s\t%s\n", p.Kind, s.PathOf(p.Rel, roots), p.Msg)
+		} else {
+			fmt.Printf("#%s\t%s\n", p.Kind, s.PathOf(p.Rel, roots))
+		}
+	}
+	return len(problems) != 0
+}
+
+func main() {
+	var roots dnav.Roots
+
+	rdFlags()
+	args := flag.Args()
+	if len(args) > 1 {
+		usage()
+	}
+	dnav.RdRoots(&roots)
+	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+
+	snaps := snapshots(args, roots)
+	if len(snaps) == 0 {
+		log.Fatal("no dumps")
+	}
+	bad := false
+	for i := range snaps {
+		var prev *dnav.Snapshot
+		if i > 0 {
+			prev = &snaps[i-1]
+		} else if writeFlag {
+			var err error
+			if prev, err = dnav.PrevSnapshot(snaps[i].Date, roots); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if verify(&snaps[i], prev, roots) {
+			bad = true
+		}
+	}
+	if bad {
+		os.Exit(1)
+	}
+}
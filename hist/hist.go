@@ -2,17 +2,18 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha1"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
+	"regexp"
+	"runtime"
 	"strings"
 	"time"
-	"unicode/utf8"
 
 	"github.com/paurea/dump/dnav"
 	"github.com/sergi/go-diff/diffmatchpatch"
@@ -22,6 +23,22 @@ var (
 	debug        bool
 	mChangesFlag bool
 	txtFlag      bool
+	noHashFlag   bool
+	maxDiffSize  int64
+	nProcs       int
+	indexFlag    bool
+	listFlag     bool
+	outFmt       string
+	patchDir     string
+	mergeFlag    bool
+	revertFlag   bool
+	inPlace      bool
+	lineRange    string
+	norm         *dnav.Normalizer
+
+	index *dnav.Index
+	roots dnav.Roots
+	cmp   *dnav.Comparer
 
 	verbose bool
 
@@ -39,6 +56,22 @@ func rdFlags() {
 	v := flag.Bool("v", false, "verbose flag")
 	c := flag.Bool("c", false, "changes, no diffs flag")
 	t := flag.Bool("t", false, "txt flag")
+	f := flag.Bool("f", false, "fast, do not hash versions with the same size and different mtimes, take them as different")
+	z := flag.Int64("z", dnav.DefMaxSize, "max size in bytes of files to diff")
+	p := flag.Int("p", runtime.NumCPU(), "# of directories and files read concurrently")
+	i := flag.Bool("i", false, "use the index of the dump")
+	l := flag.Bool("l", false, "list the versions, no diffs")
+	o := flag.String("o", "", "output format: git for a git fast-import stream, mbox or patch for patches, csv for a timeline")
+	pd := flag.String("O", ".", "directory for the patches of -o=patch")
+	mg := flag.Bool("M", false, "merge the change made in the version of the file into the live file")
+	r := flag.Bool("r", false, "revert the change made in the version of the file from the live file")
+	ip := flag.Bool("inplace", false, "write the merge or revert in place, keeping the live file with .orig")
+	ws := flag.Bool("w", false, "ignore all white space")
+	bs := flag.Bool("b", false, "ignore changes in the amount of white space")
+	bl := flag.Bool("B", false, "ignore blank lines")
+	cr := flag.Bool("e", false, "ignore the carriage return at the end of the lines")
+	ign := flag.String("I", "", "ignore the lines matching the regexp")
+	lr := flag.String("L", "", "line range start,end of the newest version to follow, each a line number or /regexp/")
 
 	y := flag.Bool("y", false, "filter yearly")
 	m := flag.Bool("m", false, "filter monthly")
@@ -52,6 +85,33 @@ func rdFlags() {
 	dnav.Debug = *db
 	mChangesFlag = *c
 	txtFlag = *t
+	noHashFlag = *f
+	maxDiffSize = *z
+	nProcs = *p
+	if nProcs < 1 {
+		nProcs = 1
+	}
+	indexFlag = *i
+	listFlag = *l
+	outFmt = *o
+	patchDir = *pd
+	mergeFlag = *mg
+	revertFlag = *r
+	inPlace = *ip
+	lineRange = *lr
+	if (mergeFlag || revertFlag) && (*ws || *bs || *bl || *cr || *ign != "") {
+		log.Fatal("the -w -b -B -e -I options do not apply to -M and -r, which need the lines as they are")
+	}
+	if *ws || *bs || *bl || *cr || *ign != "" {
+		norm = &dnav.Normalizer{SpaceChange: *bs, AllSpace: *ws, BlankLines: *bl, LineEnds: *cr}
+		if *ign != "" {
+			re, err := regexp.Compile(*ign)
+			if err != nil {
+				log.Fatal(err)
+			}
+			norm.Ignore = re
+		}
+	}
 
 	verbose = *v
 
@@ -73,7 +133,7 @@ func Dprintf(format string, a ...interface{}) (n int, err error) {
 }
 
 func usage() {
-	log.Fatal("hist [-Dvc] [-ymdh] [-s=earliestPath] file_path")
+	log.Fatal("hist [-Dvcfil] [-wbBe] [-I=regexp] [-Mr] [-inplace] [-ymdh] [-z=maxDiffSize] [-p=nprocs] [-s=earliestPath] [-L=start,end] [-o=git|mbox|patch|csv] [-O=dir] file_path")
 }
 
 type pathDump struct {
@@ -81,51 +141,21 @@ type pathDump struct {
 	os.FileInfo
 }
 
-func pathsBeforeRec(dDate dnav.DumpDate, roots *dnav.Roots, root string, from dnav.DumpDate) (paths []string, err error) {
-
-	files, err := ioutil.ReadDir(root)
+func pathsBeforeFrom(dDate dnav.DumpDate, from dnav.DumpDate, roots dnav.Roots) (paths []string, err error) {
+	Dprintf("pathsBeforeFrom\n")
+	var snaps []dnav.Snapshot
+	if index != nil {
+		snaps, err = index.ListSnapshots(roots, from)
+	} else {
+		snaps, err = dnav.ListSnapshots(roots, from, nProcs)
+	}
 	if err != nil {
 		return nil, err
 	}
-	found := 0
-	for _, f := range files {
-		fName := f.Name()
-		if _, err := strconv.Atoi(fName); err == nil {
-			found++
-			pathRec := root + "/" + fName
-			d, err := dnav.ParseDumpPath(pathRec, *roots)
-			if err != nil || (&d).IsBeforeSparse(from) {
-				continue
-			}
-			files, err := pathsBeforeRec(dDate, roots, pathRec, from)
-			if err == nil {
-				paths = append(paths, files...)
-			}
-		}
-	}
-	if found == 0 {
-		return []string{root}, nil
-	}
-
-	return paths, nil
-}
-
-func pathsBeforeFrom(dDate dnav.DumpDate, from dnav.DumpDate, roots dnav.Roots) (paths []string, err error) {
-	Dprintf("pathsBeforeFrom\n")
-	paths, err = pathsBeforeRec(dDate, &roots, roots.DumpRoot, from)
-	ps := paths
-	paths = nil
 	lastD := dDate
 	Dprintf("filtering paths\n")
-	for _, p := range ps {
-		d, err2 := dnav.ParseDumpPath(p, roots)
-		if err2 != nil {
-			return nil, err2
-		}
-		if (&d).IsBefore(from) {
-			continue
-		}
-
+	for _, s := range snaps {
+		p, d := s.Path, s.Date
 		if (&d).IsAfter(dDate) {
 			continue
 		}
@@ -147,134 +177,95 @@ func pathsBeforeFrom(dDate dnav.DumpDate, from dnav.DumpDate, roots dnav.Roots)
 	return paths, nil
 }
 
-func fmtDiff(diffs []diffmatchpatch.Diff, curr *File, new *File) string {
-	var buff bytes.Buffer
-	nlRight := 0
-	nlLeft := 0
-	Dprintf("\nDIFFs--\n")
-	for _, diff := range diffs {
-		text := diff.Text
-		nlDiff := strings.Count(text, "\n")
-		if len(text) == 0 {
-			continue
+const blockSize = 64 * 1024
+
+//changedBytes estimates how much two files differ reading them
+//as streams and comparing them block by block. It is an upper bound:
+//after an insertion or deletion the content shifts and all the
+//blocks after it count as changed.
+func changedBytes(f *dnav.File, f2 *dnav.File) (nBytes int64, nBlocks int, err error) {
+	fd, err := os.Open(f.Path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fd.Close()
+	fd2, err := os.Open(f2.Path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fd2.Close()
+	b := make([]byte, blockSize)
+	b2 := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(fd, b)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, 0, err
 		}
-		diffStr := ""
-		switch diff.Type {
-		case diffmatchpatch.DiffInsert:
-			Dprintf(">--insert %d, (%d %d)\n", nlDiff, nlLeft, nlRight)
-			buff.WriteString(fmt.Sprintf("\n%s:%d,%d %s:%d,%d\n", curr.path, nlLeft+1, nlLeft+1, new.path, nlRight+1, nlRight+nlDiff+1))
-			for i := nlRight; i < nlRight+nlDiff-1; i++ {
-				diffStr += ">" + new.lines[i] + "\n"
-			}
-			if nlDiff == 0 {
-				//diffStr += "^" + text + "^" + "\n"
-				diffStr += ">" + new.lines[nlRight] + "\n" //TODO: SHOULD MERGE continuous runs
-			}
-			nlRight += nlDiff
-		case diffmatchpatch.DiffDelete:
-			Dprintf("<--delete %d, (%d %d)\n", nlDiff, nlLeft, nlRight)
-			buff.WriteString(fmt.Sprintf("\n%s:%d,%d %s:%d,%d\n", curr.path, nlLeft+1, nlLeft+nlDiff+1, new.path, nlRight+1, nlRight+1))
-			for i := nlLeft; i < nlLeft+nlDiff; i++ {
-				diffStr += "<" + curr.lines[i] + "\n"
-			}
-			if nlDiff == 0 {
-				//diffStr += "^" + text + "^" + "\n"
-				diffStr += "<" + curr.lines[nlLeft] + "\n" //TODO: SHOULD MERGE continuous runs
-			}
-			nlLeft += nlDiff
-		case diffmatchpatch.DiffEqual:
-			Dprintf("=--match %d, (%d %d)\n", nlDiff, nlLeft, nlRight)
-			nlLeft += nlDiff
-			nlRight += nlDiff
+		n2, err2 := io.ReadFull(fd2, b2)
+		if err2 != nil && err2 != io.EOF && err2 != io.ErrUnexpectedEOF {
+			return 0, 0, err2
 		}
-		if diff.Type != diffmatchpatch.DiffEqual {
-			_, _ = buff.WriteString("\n" + diffStr)
+		if n == 0 && n2 == 0 {
+			break
+		}
+		if n != n2 || !bytes.Equal(b[:n], b2[:n2]) {
+			nBlocks++
+			if n > n2 {
+				nBytes += int64(n)
+			} else {
+				nBytes += int64(n2)
+			}
 		}
-
 	}
-
-	return buff.String()
-}
-
-type File struct {
-	path  string
-	lines []string
-	txt   string
-	sha   [20]byte
-	info  os.FileInfo
+	return nBytes, nBlocks, nil
 }
 
-func (f *File) String() string {
-	s := fmt.Sprintf("%s ", f.path)
-	if f.info == nil {
-		s += " ###bad info###"
-		return s
-	}
-	s += fmt.Sprintf("%d ", f.info.Size())
-	s += fmt.Sprintf("%#o ", f.info.Mode())
-	s += fmt.Sprintf("%v ", f.info.ModTime())
-	if f.info.IsDir() {
-		s += fmt.Sprintf(" d")
-	} else {
-		s += fmt.Sprintf(" f")
+//typeChange describes the change of a path between directory and file
+func typeChange(f *dnav.File, f2 *dnav.File) string {
+	if f.IsDir() {
+		return "directory became a file"
 	}
-
-	return s
-}
-
-func (f *File) isText() bool {
-	return !strings.ContainsRune(f.txt, utf8.RuneError)
+	return "file became a directory"
 }
 
-func (f *File) isDir() bool {
-	return f.info != nil && f.info.IsDir()
-}
-
-func (f *File) readDir() (txt string, err error) {
-	files, err := ioutil.ReadDir(f.path)
-	if err != nil {
-		return "", err
-	}
-	for _, fi := range files {
-		fp := &File{path: fi.Name(), info: fi}
-		txt += fmt.Sprintf("\t[]\t%s\n", fp)
+//suffixed returns the paths with the suffix
+func suffixed(paths []string, suff string) (sp []string) {
+	for _, p := range paths {
+		sp = append(sp, p+suff)
 	}
-	return txt, nil
+	return sp
 }
 
-func readFile(path string) (f *File, exists bool, err error) {
-	var buf []byte
-	f = &File{path: path, txt: ""}
-	f.info, err = os.Stat(f.path)
-	if os.IsNotExist(err) {
-		return f, false, nil
-	}
-	if err != nil {
-		return f, false, err
-	}
-	exists = true
-	if f.info.IsDir() {
-		f.txt, err = f.readDir()
-		if err != nil {
-			return f, exists, err
+//doVersions prints the path of each distinct version of the file
+func doVersions(paths []string, dPath string) {
+	suff := dPath[len(paths[0]):]
+	fs := cmp.Prefetch(suffixed(paths, suff), nProcs)
+	var last *dnav.File
+	for i, f := range fs {
+		fs[i].File = nil //only the last version is kept, not to hold the whole history
+		if f.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s\n", f.File.Path, f.Err)
+			continue
 		}
-		f.sha = sha1.Sum([]byte(f.txt)) //BETTER WAYS md5? no sec concern here, which is faster?
-	} else {
-		buf, err = ioutil.ReadFile(path)
-		if err == nil {
-			exists = true
-			f.txt = string(buf)
-			f.sha = sha1.Sum(buf) //BETTER WAYS? no sec concern here, which is faster?
-		} else if os.IsNotExist(err) {
-			return f, false, nil
+		if !f.Exists {
+			last = nil
+			continue
 		}
+		if last != nil {
+			same, err := last.SameVersion(f.File)
+			last.Unload()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s\n", f.File.Path, err)
+				continue
+			}
+			if same {
+				last = f.File
+				continue
+			}
+		}
+		fmt.Println(f.File.Path)
+		last = f.File
 	}
-	f.lines = strings.Split(f.txt, "\n")
-	return f, exists, err
-}
-
-func (f *File) hasEqContent(f2 *File) bool {
-	return bytes.Compare(f.sha[:], f2.sha[:]) == 0
 }
 
 func doDiffs(paths []string, dPath string) {
@@ -283,33 +274,26 @@ func doDiffs(paths []string, dPath string) {
 	onlyChanges := mChangesFlag
 
 	suff := dPath[len(paths[0]):]
-	var curr *File
-	new := &File{}
+	fs := cmp.Prefetch(suffixed(paths, suff), nProcs)
+	var curr *dnav.File
+	var new *dnav.File
 
 	exists := false
 	newexists := false
 
 	j := 0
 	for ; j < len(paths); j++ {
-		curr, newexists, err = readFile(paths[j] + suff)
+		curr, newexists, err = fs[j].File, fs[j].Exists, fs[j].Err
 		if !newexists {
 			continue
 		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s %s\n", curr.path, err)
+			fmt.Fprintf(os.Stderr, "%s %s\n", curr.Path, err)
 			continue
 		}
-		if !mChangesFlag && !txtFlag {
-			isBin := !curr.isText()
-			onlyChanges = isBin
-			if isBin {
-				Dprintf("binary file %s\n", curr.path)
-			}
-
-		}
 		fmt.Printf("#create\t%s\n", curr)
-		if curr.isDir() && verbose {
-			fmt.Printf("%s\n", curr.txt)
+		if curr.IsDir() && verbose {
+			fmt.Printf("%s\n", curr.Txt)
 		}
 		exists = true
 		break
@@ -317,18 +301,18 @@ func doDiffs(paths []string, dPath string) {
 	currMeta := fmt.Sprintf("%s", curr)
 
 	for i := j; i < len(paths); i++ {
-		*new = *curr
 		dmp := diffmatchpatch.New()
 		newexists = false
-		new, newexists, err = readFile(paths[i] + suff)
+		new, newexists, err = fs[i].File, fs[i].Exists, fs[i].Err
+		fs[i].File = nil //only curr and new are kept, not to hold the whole history
 
 		if !newexists && exists {
-			fmt.Printf("#delete\t%s -> %s\n", curr.path, new.path)
+			fmt.Printf("#delete\t%s -> %s\n", curr.Path, new.Path)
 			exists = false
 			continue
 		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s %s\n", new.path, err)
+			fmt.Fprintf(os.Stderr, "%s %s\n", new.Path, err)
 			continue
 		}
 		if !exists && newexists {
@@ -338,41 +322,404 @@ func doDiffs(paths []string, dPath string) {
 		if !exists {
 			continue
 		}
-		if !mChangesFlag && !txtFlag {
-			isBin := !new.isText()
-			onlyChanges = isBin
-			if isBin {
-				Dprintf("binary file %s\n", new.path)
-			}
-		}
-
 		newMeta := fmt.Sprintf("%s", new)
 
-		if !curr.hasEqContent(new) {
+		same, err := curr.SameVersion(new)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s\n", new.Path, err)
+			continue
+		}
+		if !same && curr.IsDir() != new.IsDir() && (curr.TooBig() || new.TooBig()) {
+			fmt.Printf("#write\t%s\t%s\n", newMeta, typeChange(curr, new))
+		} else if !same && (curr.TooBig() || new.TooBig()) {
+			nBytes, nBlocks, err := changedBytes(curr, new)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s\n", new.Path, err)
+			} else {
+				fmt.Printf("#write\t%s\t~%d bytes changed in %d blocks\n", newMeta, nBytes, nBlocks)
+			}
+		} else if !same {
+			if !mChangesFlag {
+				if err := curr.Load(); err != nil {
+					fmt.Fprintf(os.Stderr, "%s %s\n", curr.Path, err)
+					continue
+				}
+				if err := new.Load(); err != nil {
+					fmt.Fprintf(os.Stderr, "%s %s\n", new.Path, err)
+					continue
+				}
+			}
+			if !mChangesFlag && !txtFlag {
+				isBin := !new.IsText()
+				onlyChanges = isBin
+				if isBin {
+					Dprintf("binary file %s\n", new.Path)
+				}
+			}
 			if onlyChanges {
 				fmt.Printf("#write\t%s\n", newMeta)
 			}
-			if new.isDir() && verbose {
+			if new.IsDir() && verbose {
 				fmt.Printf("#write\t%s\n", newMeta)
-				fmt.Printf("%s\n", new.txt)
+				fmt.Printf("%s\n", new.Txt)
 			}
-			diffs := dmp.DiffMain(curr.txt, new.txt, true)
-			diffs = dmp.DiffCleanupSemantic(diffs)
-			if !onlyChanges {
-				fmt.Println(fmtDiff(diffs, curr, new))
+			if !onlyChanges && norm != nil && !new.IsDir() {
+				fmt.Println(dnav.NormDiff(curr.Path, curr.Txt, new.Path, new.Txt, norm))
+			} else if !onlyChanges {
+				diffs := dmp.DiffMain(curr.Txt, new.Txt, true)
+				diffs = dmp.DiffCleanupSemantic(diffs)
+				fmt.Println(dnav.FmtDiff(diffs, curr.Path, curr.Lines, new.Path, new.Lines))
 			}
 		} else if currMeta[len(paths[i]):] != newMeta[len(paths[i]):] {
 			//using os.SameFile here is not what I want, I want only the metada *I* regularly change
 			fmt.Printf("#wstat\t%s\n", newMeta)
 		}
+		curr.Unload()
 		curr = new
 		currMeta = newMeta
 	}
 }
 
+//snapshotsOf returns the snapshots of the paths of pathsBeforeFrom
+func snapshotsOf(paths []string) (snaps []dnav.Snapshot, err error) {
+	for _, p := range paths {
+		d, err := dnav.ParseDumpPath(p, roots)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, dnav.Snapshot{Path: p, Date: d})
+	}
+	return snaps, nil
+}
+
+//doGit writes the versions of the file or directory as a git fast-import stream
+func doGit(paths []string, path string) error {
+	snaps, err := snapshotsOf(paths)
+	if err != nil {
+		return err
+	}
+	rel, err := dnav.RelPath(path, roots)
+	if err != nil {
+		return err
+	}
+	n, err := dnav.ExportGit(os.Stdout, snaps, cmp, rel, "refs/heads/master")
+	Dprintf("%d commits exported\n", n)
+	return err
+}
+
+//doPatches writes the changes of the file as patches, in an mbox
+//to the standard output or numbered files in patchDir
+func doPatches(paths []string, path string, mbox bool) error {
+	snaps, err := snapshotsOf(paths)
+	if err != nil {
+		return err
+	}
+	rel, err := dnav.RelPath(path, roots)
+	if err != nil {
+		return err
+	}
+	versions, err := cmp.History(snaps, rel, nProcs)
+	if err != nil {
+		return err
+	}
+	changes := dnav.Changes(versions)
+	for i := range changes {
+		c := &changes[i]
+		if mbox {
+			if err := c.WritePatch(os.Stdout, rel, i+1, len(changes), cmp); err != nil {
+				return err
+			}
+			continue
+		}
+		name := fmt.Sprintf("%04d-%s-%s.patch", i+1, strings.Replace(c.Snapshot.Date.Name(), "/", "-", -1), filepath.Base(rel))
+		fd, err := os.Create(filepath.Join(patchDir, name))
+		if err != nil {
+			return err
+		}
+		err = c.WritePatch(fd, rel, i+1, len(changes), cmp)
+		if cerr := fd.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(fd.Name())
+	}
+	return nil
+}
+
+//doCSV writes a timeline of the file or of the files in the directory as CSV
+func doCSV(paths []string, path string) error {
+	snaps, err := snapshotsOf(paths)
+	if err != nil {
+		return err
+	}
+	rel, err := dnav.RelPath(path, roots)
+	if err != nil {
+		return err
+	}
+	rows, err := cmp.Timeline(snaps, rel, nProcs)
+	if err != nil {
+		return err
+	}
+	return dnav.WriteTimelineCSV(os.Stdout, rows, roots)
+}
+
+//changeAt finds the change of the file which made its version in the snapshot of date d
+func changeAt(paths []string, rel string, d dnav.DumpDate) (c *dnav.Change, err error) {
+	snaps, err := snapshotsOf(paths)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := cmp.History(dnav.Until(snaps, d), rel, nProcs)
+	if err != nil {
+		return nil, err
+	}
+	changes := dnav.Changes(versions)
+	for i := len(changes) - 1; i >= 0 && changes[i].Kind != dnav.Delete; i-- {
+		if changes[i].Kind == dnav.Create || changes[i].Kind == dnav.Write {
+			return &changes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no version of %s in the dump %s", roots.RootName+rel, d.Name())
+}
+
+//readText reads a file to merge it, which has to be text
+func readText(path string) (string, error) {
+	f, _, err := cmp.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if f.IsDir() {
+		return "", errors.New("not a text file: " + path)
+	}
+	if err := f.Load(); err != nil {
+		return "", err
+	}
+	if !f.IsText() {
+		return "", errors.New("not a text file: " + path)
+	}
+	return f.Txt, nil
+}
+
+//A pick is the change made in a version of a file in the dump,
+//from the version before it, and the live file
+type pick struct {
+	live, base, theirs             string //texts
+	livePath, basePath, theirsPath string
+}
+
+//pickChange reads the versions of the file for the change made in the version at path
+func pickChange(paths []string, path string) (p *pick, err error) {
+	if !dnav.IsDump(path, roots) {
+		return nil, errors.New("the version has to be in the dump: " + path)
+	}
+	d, err := dnav.ParseDumpPath(path, roots)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := dnav.RelPath(path, roots)
+	if err != nil {
+		return nil, err
+	}
+	c, err := changeAt(paths, rel, d)
+	if err != nil {
+		return nil, err
+	}
+	p = &pick{livePath: dnav.LivePath(rel, roots), basePath: "/dev/null", theirsPath: c.Path}
+	if p.live, err = readText(p.livePath); err != nil {
+		return nil, err
+	}
+	if p.theirs, err = readText(c.Path); err != nil {
+		return nil, err
+	}
+	if c.Prev != nil {
+		if p.base, err = readText(c.Prev.Path); err != nil {
+			return nil, err
+		}
+		p.basePath = c.Prev.Path
+	}
+	return p, nil
+}
+
+//writeFile writes the text to a temporary file in the directory of path and renames
+//it over path, so that a failed write leaves the file as it was
+func writeFile(path string, txt string, perm os.FileMode) (err error) {
+	fd, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(fd.Name())
+		}
+	}()
+	_, err = fd.WriteString(txt)
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(fd.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+//perm returns the permissions of the live file, for the files written next to it
+func (p *pick) perm() (os.FileMode, error) {
+	fi, err := os.Stat(p.livePath)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Mode().Perm(), nil
+}
+
+//output prints the new text of the live file or, with -inplace, writes it in
+//its place, keeping the live file with the .orig suffix
+func (p *pick) output(txt string) error {
+	if !inPlace {
+		_, err := os.Stdout.WriteString(txt)
+		return err
+	}
+	perm, err := p.perm()
+	if err != nil {
+		return err
+	}
+	if err := writeFile(p.livePath+".orig", p.live, perm); err != nil {
+		return err
+	}
+	//the rename replaces the file a symbolic link points to, not the link
+	path, err := filepath.EvalSymlinks(p.livePath)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, txt, perm)
+}
+
+//doMerge merges the change made in the version of the file in the dump at path, from
+//the version before it, into the live file. It returns the number of conflicts.
+func doMerge(paths []string, path string) (nConflicts int, err error) {
+	p, err := pickChange(paths, path)
+	if err != nil {
+		return 0, err
+	}
+	Dprintf("merging %s from %s into %s\n", p.theirsPath, p.basePath, p.livePath)
+	merged, nConflicts := dnav.Merge3(p.live, p.base, p.theirs, [3]string{p.livePath, p.basePath, p.theirsPath})
+	return nConflicts, p.output(merged)
+}
+
+//doRevert undoes in the live file the change made in the version of the file in the dump at
+//path, applying the diff from the version before it in reverse. The hunks which cannot be
+//applied are printed or, with -inplace, written to the file with the .rej suffix.
+func doRevert(paths []string, path string) (nRejected int, err error) {
+	p, err := pickChange(paths, path)
+	if err != nil {
+		return 0, err
+	}
+	Dprintf("reverting %s from %s in %s\n", p.theirsPath, p.basePath, p.livePath)
+	hunks := dnav.Hunks(p.base, p.theirs, dnav.DiffContext)
+	for i := range hunks {
+		hunks[i] = hunks[i].Reverse()
+	}
+	reverted, rejected := dnav.ApplyHunks(p.live, hunks)
+	if err := p.output(reverted); err != nil {
+		return 0, err
+	}
+	if len(rejected) == 0 {
+		return 0, nil
+	}
+	rej := dnav.FmtUnified(p.theirsPath, p.basePath, rejected)
+	if !inPlace {
+		_, err = os.Stderr.WriteString(rej)
+		return len(rejected), err
+	}
+	perm, err := p.perm()
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(os.Stderr, "rejected hunks in %s\n", p.livePath+".rej")
+	return len(rejected), writeFile(p.livePath+".rej", rej, perm)
+}
+
+//A rangeDiff is the diff of the range of lines followed by -L in a change of the file
+type rangeDiff struct {
+	c          *dnav.Change
+	start, end int
+	hunks      []dnav.Hunk
+}
+
+//doLines prints the diffs of the changes of the file which touch a range of lines of its newest
+//version, following the range back through the versions as the lines around it shift
+func doLines(paths []string, path string) error {
+	snaps, err := snapshotsOf(paths)
+	if err != nil {
+		return err
+	}
+	rel, err := dnav.RelPath(path, roots)
+	if err != nil {
+		return err
+	}
+	versions, err := cmp.History(snaps, rel, nProcs)
+	if err != nil {
+		return err
+	}
+	var changes []*dnav.Change
+	for _, c := range dnav.Changes(versions) {
+		if c.Kind == dnav.Create || c.Kind == dnav.Write {
+			c := c
+			changes = append(changes, &c)
+		}
+	}
+	if len(changes) == 0 {
+		return errors.New("no versions of " + roots.RootName + rel)
+	}
+	c := changes[len(changes)-1]
+	txt, err := readText(c.Path)
+	if err != nil {
+		return err
+	}
+	start, end, err := dnav.ParseLineRange(lineRange, txt)
+	if err != nil {
+		return err
+	}
+	var diffs []rangeDiff
+	for k := len(changes) - 1; k >= 0 && start < end; k-- {
+		c := changes[k]
+		ptxt, pstart, pend := "", 0, 0
+		if c.Prev != nil {
+			if ptxt, err = readText(c.Prev.Path); err != nil {
+				return err
+			}
+			pstart, pend = dnav.TrackRange(ptxt, txt, start, end, norm)
+		}
+		hunks := dnav.RangeHunks(ptxt, pstart, pend, txt, start, end, dnav.DiffContext, norm)
+		if len(hunks) > 0 {
+			diffs = append(diffs, rangeDiff{c, start, end, hunks})
+		}
+		if c.Prev == nil {
+			break
+		}
+		txt, start, end = ptxt, pstart, pend
+	}
+	for i := len(diffs) - 1; i >= 0; i-- {
+		d := &diffs[i]
+		fmt.Printf("#%s\t%s\tlines %d,%d\n", d.c.Kind, d.c.Path, d.start+1, d.end)
+		if mChangesFlag {
+			continue
+		}
+		prevPath := "/dev/null"
+		if d.c.Prev != nil {
+			prevPath = d.c.Prev.Path
+		}
+		fmt.Print(dnav.FmtUnified(prevPath, d.c.Path, d.hunks))
+	}
+	return nil
+}
+
 func main() {
 	var (
-		roots    dnav.Roots
 		path     string
 		fromDate dnav.DumpDate
 	)
@@ -397,6 +744,18 @@ func main() {
 	Dprintf("path %s\n", path)
 	dnav.RdRoots(&roots)
 	Dprintf("mainRoot: %v, dumpRoot: %v, rootName: %v\n", roots.MainRoot, roots.DumpRoot, roots.RootName)
+	cmp = &dnav.Comparer{Roots: roots, NoHash: noHashFlag, MaxSize: maxDiffSize, Norm: norm}
+
+	if indexFlag {
+		var err error
+		if index, err = dnav.OpenIndex(roots); err != nil {
+			log.Fatal(err)
+		}
+		if err = index.Refresh(roots, nProcs); err != nil {
+			log.Fatal(err)
+		}
+		cmp.Index = index
+	}
 
 	if earliestPath != "" {
 		var err error
@@ -442,5 +801,57 @@ func main() {
 		log.Fatal(err)
 	}
 	Dprintf(" %s: %s\n", files, dPath)
-	doDiffs(files, dPath)
+	if len(files) == 0 {
+		log.Fatal("no dumps")
+	}
+	status := 0
+	switch {
+	case mergeFlag:
+		n, err := doMerge(files, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n > 0 {
+			fmt.Fprintf(os.Stderr, "%d conflicts\n", n)
+			status = 1
+		}
+	case revertFlag:
+		n, err := doRevert(files, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n > 0 {
+			fmt.Fprintf(os.Stderr, "%d hunks rejected\n", n)
+			status = 1
+		}
+	case outFmt == "git":
+		if err := doGit(files, path); err != nil {
+			log.Fatal(err)
+		}
+	case outFmt == "mbox" || outFmt == "patch":
+		if err := doPatches(files, path, outFmt == "mbox"); err != nil {
+			log.Fatal(err)
+		}
+	case outFmt == "csv":
+		if err := doCSV(files, path); err != nil {
+			log.Fatal(err)
+		}
+	case outFmt != "":
+		fmt.Fprintf(os.Stderr, "bad output format %s\n", outFmt)
+		usage()
+	case lineRange != "":
+		if err := doLines(files, path); err != nil {
+			log.Fatal(err)
+		}
+	case listFlag:
+		doVersions(files, dPath)
+	default:
+		doDiffs(files, dPath)
+	}
+	if index != nil {
+		if err := index.Save(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	os.Exit(status)
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paurea/dump/dnav"
+)
+
+//lines returns a text of n lines, with the ones in repl replaced
+func lines(n int, repl map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if l, ok := repl[i]; ok {
+			b.WriteString(l + "\n")
+			continue
+		}
+		fmt.Fprintf(&b, "l%d\n", i)
+	}
+	return b.String()
+}
+
+//mkDump makes a dump with a snapshot for each version of f.txt,
+//returning the path of the live file and the paths of the snapshots
+func mkDump(t *testing.T, versions []string) (live string, paths []string) {
+	tmp := t.TempDir()
+	os.MkdirAll(tmp+"/ROOT", 0755)
+	os.MkdirAll(tmp+"/dump", 0755)
+	os.Setenv(dnav.MainRootVar, tmp+"/ROOT")
+	os.Setenv(dnav.MainDumpVar, tmp+"/dump")
+	roots = dnav.Roots{}
+	dnav.RdRoots(&roots)
+	cmp = dnav.NewComparer(roots)
+	nProcs = 1
+	inPlace = true
+
+	live = tmp + "/ROOT/f.txt"
+	t1 := time.Date(2017, 5, 10, 16, 5, 0, 0, time.Local)
+	for i, txt := range versions {
+		ti := t1.Add(time.Duration(i) * 24 * time.Hour)
+		os.WriteFile(live, []byte(txt), 0644)
+		os.Chtimes(live, ti, ti)
+		s, _, err := dnav.TakeSnapshot(roots, ti, nil)
+		if err != nil {
+			t.Fatalf("should not error: %s", err)
+		}
+		paths = append(paths, s.Path)
+	}
+	return live, paths
+}
+
+var versions = []string{
+	lines(12, nil),
+	lines(12, map[int]string{2: "L2"}),
+	lines(12, map[int]string{2: "L2", 11: "L11"}),
+}
+
+//writeLive changes the live file after the dump
+func writeLive(t *testing.T, live string, txt string, perm os.FileMode) {
+	if err := os.WriteFile(live, []byte(txt), perm); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if err := os.Chmod(live, perm); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+}
+
+//checkFile checks the text and permissions of a file
+func checkFile(t *testing.T, path string, txt string, perm os.FileMode) {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if string(b) != txt {
+		t.Errorf("bad text of %s, got:\n%s\nwant:\n%s", path, b, txt)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	if fi.Mode().Perm() != perm {
+		t.Errorf("bad permissions of %s: %#o, want %#o", path, fi.Mode().Perm(), perm)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	live, paths := mkDump(t, versions)
+	rel := "/f.txt"
+	old := lines(13, map[int]string{11: "L11"})
+	writeLive(t, live, old, 0640)
+	n, err := doMerge(paths, paths[1]+"/ROOT"+rel)
+	if err != nil || n != 0 {
+		t.Fatalf("should merge without conflicts: %d %v", n, err)
+	}
+	checkFile(t, live, lines(13, map[int]string{2: "L2", 11: "L11"}), 0640)
+	checkFile(t, live+".orig", old, 0640)
+
+	//the change is already in the live file
+	writeLive(t, live, old, 0600)
+	n, err = doMerge(paths, paths[2]+"/ROOT"+rel)
+	if err != nil || n != 0 {
+		t.Fatalf("should merge without conflicts: %d %v", n, err)
+	}
+	checkFile(t, live, old, 0600)
+
+	conflict := lines(12, map[int]string{2: "X2"})
+	writeLive(t, live, conflict, 0600)
+	n, err = doMerge(paths, paths[1]+"/ROOT"+rel)
+	if err != nil || n != 1 {
+		t.Fatalf("should find a conflict: %d %v", n, err)
+	}
+	b, _ := ioutil.ReadFile(live)
+	if !strings.Contains(string(b), "X2\n") || !strings.Contains(string(b), "L2\n") {
+		t.Errorf("should keep both sides of the conflict:\n%s", b)
+	}
+	checkFile(t, live+".orig", conflict, 0600)
+}
+
+func TestRevert(t *testing.T) {
+	live, paths := mkDump(t, versions)
+	rel := "/f.txt"
+	newest := lines(13, map[int]string{2: "L2", 11: "L11"})
+	writeLive(t, live, newest, 0640)
+	n, err := doRevert(paths, paths[1]+"/ROOT"+rel)
+	if err != nil || n != 0 {
+		t.Fatalf("should revert without rejects: %d %v", n, err)
+	}
+	checkFile(t, live, lines(13, map[int]string{11: "L11"}), 0640)
+	checkFile(t, live+".orig", newest, 0640)
+	if _, err := os.Stat(live + ".rej"); !os.IsNotExist(err) {
+		t.Errorf("should not write the rejects if there are none")
+	}
+
+	//the change of the last line is gone from the live file
+	other := lines(10, map[int]string{2: "L2"})
+	writeLive(t, live, other, 0600)
+	n, err = doRevert(paths, paths[2]+"/ROOT"+rel)
+	if err != nil || n != 1 {
+		t.Fatalf("should reject the hunk: %d %v", n, err)
+	}
+	checkFile(t, live, other, 0600)
+	b, err := ioutil.ReadFile(live + ".rej")
+	if err != nil || !strings.Contains(string(b), "-L11\n") || !strings.Contains(string(b), "+l11\n") {
+		t.Errorf("should write the rejected hunk: %v\n%s", err, b)
+	}
+	checkFile(t, live+".rej", string(b), 0600)
+}
+
+func TestLines(t *testing.T) {
+	_, paths := mkDump(t, versions)
+	path := roots.MainRoot + "/f.txt"
+	out, err := ioutil.TempFile(t.TempDir(), "out")
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+
+	mChangesFlag = true
+	defer func() { mChangesFlag = false }()
+	lineRange = "/L2/,+1"
+	if err := doLines(paths, path); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	lineRange = "10,12"
+	if err := doLines(paths, path); err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	b, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatalf("should not error: %s", err)
+	}
+	want := fmt.Sprintf("#create\t%s/ROOT/f.txt\tlines 2,2\n", paths[0]) +
+		fmt.Sprintf("#write\t%s/ROOT/f.txt\tlines 2,2\n", paths[1]) +
+		fmt.Sprintf("#create\t%s/ROOT/f.txt\tlines 10,12\n", paths[0]) +
+		fmt.Sprintf("#write\t%s/ROOT/f.txt\tlines 10,12\n", paths[2])
+	if string(b) != want {
+		t.Errorf("bad changes of the lines, got:\n%s\nwant:\n%s", b, want)
+	}
+}